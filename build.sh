@@ -19,6 +19,30 @@ GOOS=linux GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xs
 GOOS=linux GOARCH=arm64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-pcm-linux-arm64" ./cmd/xsum-pcm
 GOOS=windows GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-pcm.exe" ./cmd/xsum-pcm
 
+GOOS=darwin GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-img-macos-amd64" ./cmd/xsum-img
+GOOS=darwin GOARCH=arm64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-img-macos-arm64" ./cmd/xsum-img
+GOOS=linux GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-img-linux-amd64" ./cmd/xsum-img
+GOOS=linux GOARCH=arm64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-img-linux-arm64" ./cmd/xsum-img
+GOOS=windows GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-img.exe" ./cmd/xsum-img
+
+GOOS=darwin GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-text-macos-amd64" ./cmd/xsum-text
+GOOS=darwin GOARCH=arm64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-text-macos-arm64" ./cmd/xsum-text
+GOOS=linux GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-text-linux-amd64" ./cmd/xsum-text
+GOOS=linux GOARCH=arm64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-text-linux-arm64" ./cmd/xsum-text
+GOOS=windows GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-text.exe" ./cmd/xsum-text
+
+GOOS=darwin GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-json-macos-amd64" ./cmd/xsum-json
+GOOS=darwin GOARCH=arm64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-json-macos-arm64" ./cmd/xsum-json
+GOOS=linux GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-json-linux-amd64" ./cmd/xsum-json
+GOOS=linux GOARCH=arm64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-json-linux-arm64" ./cmd/xsum-json
+GOOS=windows GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-json.exe" ./cmd/xsum-json
+
+GOOS=darwin GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-archive-macos-amd64" ./cmd/xsum-archive
+GOOS=darwin GOARCH=arm64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-archive-macos-arm64" ./cmd/xsum-archive
+GOOS=linux GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-archive-linux-amd64" ./cmd/xsum-archive
+GOOS=linux GOARCH=arm64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-archive-linux-arm64" ./cmd/xsum-archive
+GOOS=windows GOARCH=amd64 go build -ldflags "-X main.Version=$version" -o "$out/xsum-archive.exe" ./cmd/xsum-archive
+
 docker build . --build-arg "version=$version" -t "sclevine/xsum:$version"
 docker tag "sclevine/xsum:$version" "sclevine/xsum:latest"
 docker build . -f Dockerfile.full --build-arg "version=$version" -t "sclevine/xsum:full-$version"
@@ -1,18 +1,23 @@
 package cli
 
 import (
+	"crypto/hmac"
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
+	"errors"
 	"fmt"
 	"hash"
 	"hash/adler32"
 	"hash/crc32"
 	"hash/crc64"
 	"hash/fnv"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	"golang.org/x/crypto/blake2b"
 	"golang.org/x/crypto/blake2s"
@@ -21,10 +26,122 @@ import (
 	"golang.org/x/crypto/sha3"
 
 	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/encoding"
+	"github.com/sclevine/xsum/wasm"
 )
 
-// note: algorithm names may not contain :
+// ErrKeyRequired is returned when a keyed hash function is parsed without a key.
+var ErrKeyRequired = errors.New("key required")
+
+// ParseHash returns the xsum.Hash for the named hash function.
+// Built-in hash functions are preferred, followed by registered hash functions (see RegisterHash) and plugins.
+// Plugins are validated using their xsum.PluginInfo (see Algorithms and PluginInfo), and only validated once.
+// Keyed hash functions (e.g., hmac-sha256) return ErrKeyRequired.
+// note: algorithm names may not contain : or @
 func ParseHash(alg string) (xsum.Hash, error) {
+	return ParseKeyedHash(alg, nil)
+}
+
+// ParseKeyedHash is similar to ParseHash, but keyed hash functions (e.g., hmac-sha256) use the provided key.
+// Unkeyed hash functions ignore the key.
+func ParseKeyedHash(alg string, key []byte) (xsum.Hash, error) {
+	if i := strings.LastIndex(alg, "@"); i >= 0 {
+		// chunked, e.g., sha256@64m
+		h, err := ParseKeyedHash(alg[:i], key)
+		if err != nil {
+			return nil, err
+		}
+		size, err := xsum.ParseSize(alg[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk size for `%s': %w", alg, err)
+		}
+		return xsum.NewHashChunked(h, size), nil
+	}
+	if h, err := builtinHash(alg, key); err != errUnknownHash {
+		return h, err
+	}
+	registryMu.RLock()
+	fn := registry[toSingle(alg, "-", "_", ".", "/")]
+	registryMu.RUnlock()
+	if fn != nil {
+		return fn(key)
+	}
+	// xsum plugin
+	if p, isWASM, ok := lookPlugin(alg); ok {
+		return pluginHash(alg, p, isWASM)
+	}
+	return nil, fmt.Errorf("unknown algorithm `%s'", alg)
+}
+
+var (
+	pluginsMu    sync.Mutex
+	plugins      = map[[2]string]xsum.PluginHash{}
+	pluginLimits xsum.PluginLimits
+)
+
+// SetPluginLimits restricts the execution of plugins returned by subsequent calls to ParseHash and ParseKeyedHash.
+func SetPluginLimits(limits xsum.PluginLimits) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	pluginLimits = limits
+	plugins = map[[2]string]xsum.PluginHash{}
+}
+
+// pluginHash returns a plugin, after validating its xsum.PluginInfo.
+func pluginHash(alg, path string, isWASM bool) (xsum.Hash, error) {
+	h := cachedPlugin(alg, path, isWASM)
+	if _, err := h.Info(); err != nil {
+		return nil, fmt.Errorf("invalid plugin `%s': %w", path, err)
+	}
+	return h, nil
+}
+
+// cachedPlugin returns a plugin, so that each plugin is only validated (or compiled) once.
+func cachedPlugin(alg, path string, isWASM bool) xsum.PluginHash {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	h := plugins[[2]string{alg, path}]
+	if h == nil {
+		if isWASM {
+			h = wasm.NewHashPluginLimits(alg, path, pluginLimits)
+		} else {
+			h = xsum.NewHashPluginLimits(alg, path, pluginLimits)
+		}
+		plugins[[2]string{alg, path}] = h
+	}
+	return h
+}
+
+// lookPlugin searches $PATH for a plugin, preferring executable plugins to WebAssembly plugins.
+func lookPlugin(alg string) (path string, isWASM, ok bool) {
+	if p, err := exec.LookPath("xsum-" + alg); err == nil {
+		return p, false, true
+	}
+	if p, ok := lookPathWASM("xsum-" + alg + ".wasm"); ok {
+		return p, true, true
+	}
+	return "", false, false
+}
+
+// lookPathWASM searches $PATH for a WebAssembly plugin.
+// Unlike native plugins, WebAssembly plugins do not need to be executable.
+func lookPathWASM(file string) (string, bool) {
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			continue
+		}
+		p := filepath.Join(dir, file)
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+var errUnknownHash = errors.New("unknown hash")
+
+// builtinHash returns errUnknownHash if alg is not a built-in hash function.
+func builtinHash(alg string, key []byte) (xsum.Hash, error) {
 	// order:
 	// - least info to most info
 	// - shorter abbreviation before longer
@@ -74,6 +191,24 @@ func ParseHash(alg string) (xsum.Hash, error) {
 	case "rmd160", "rmd-160", "ripemd160", "ripemd-160":
 		return xsum.NewHashFunc(xsum.HashRMD160, ripemd160.New), nil
 
+	case "treehash", "tree-hash", "sha256tree", "sha256-tree":
+		return xsum.NewHashTree(xsum.HashSHA256Tree, sha256.New, 1<<20), nil
+
+	// Keyed hashes
+
+	case "hmacsha256", "hmac-sha256":
+		return keyedHash(xsum.HashHMACSHA256, alg, key, hmacHash(sha256.New))
+	case "hmacsha512", "hmac-sha512":
+		return keyedHash(xsum.HashHMACSHA512, alg, key, hmacHash(sha512.New))
+	case "b2s256keyed", "b2s256-keyed", "b2s-256-keyed", "blake2s256keyed", "blake2s256-keyed", "blake2s-256-keyed":
+		return keyedHash(xsum.HashBlake2s256Keyed, alg, key, blake2s.New256)
+	case "b2b256keyed", "b2b256-keyed", "b2b-256-keyed", "blake2b256keyed", "blake2b256-keyed", "blake2b-256-keyed":
+		return keyedHash(xsum.HashBlake2b256Keyed, alg, key, blake2b.New256)
+	case "b2b384keyed", "b2b384-keyed", "b2b-384-keyed", "blake2b384keyed", "blake2b384-keyed", "blake2b-384-keyed":
+		return keyedHash(xsum.HashBlake2b384Keyed, alg, key, blake2b.New384)
+	case "b2b512keyed", "b2b512-keyed", "b2b-512-keyed", "blake2b512keyed", "blake2b512-keyed", "blake2b-512-keyed":
+		return keyedHash(xsum.HashBlake2b512Keyed, alg, key, blake2b.New512)
+
 	// Non-cryptographic hashes
 
 	case "crc32", "crc32ieee", "crc32-ieee":
@@ -104,25 +239,89 @@ func ParseHash(alg string) (xsum.Hash, error) {
 		return xsum.NewHashFunc(xsum.HashFNV128a, fnv.New128a), nil
 
 	default:
-		// xsum plugin
-		p, err := exec.LookPath("xsum-" + alg)
-		if err != nil {
-			return nil, fmt.Errorf("unknown algorithm `%s'", alg)
+		return nil, errUnknownHash
+	}
+}
+
+// HashConstructor returns a new xsum.Hash for a registered hash function.
+// Constructors for keyed hash functions receive the key provided to ParseKeyedHash,
+// and should return an error wrapping ErrKeyRequired if the key is empty.
+type HashConstructor func(key []byte) (xsum.Hash, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]HashConstructor{}
+	registered []Algorithm
+)
+
+// RegisterHash registers a hash function, so that ParseHash and ParseKeyedHash return it for its name or any of its aliases.
+// The xsum.Hash returned by fn must return name from its String method.
+// hashType identifies the hash function in DER-encoded metadata (e.g., for directories), and may be encoding.HashUnknown.
+// Registered hash functions take precedence over plugins.
+// The name and aliases must not conflict with built-in or previously-registered hash functions.
+func RegisterHash(name string, fn HashConstructor, hashType encoding.HashType, aliases ...string) error {
+	names := append([]string{name}, aliases...)
+	seen := map[string]bool{}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, n := range names {
+		if n == "" || strings.ContainsAny(n, ":@ \t\r\n") {
+			return fmt.Errorf("invalid algorithm name `%s'", n)
+		}
+		k := toSingle(n, "-", "_", ".", "/")
+		if _, err := builtinHash(n, nil); err != errUnknownHash {
+			return fmt.Errorf("algorithm `%s' conflicts with built-in algorithm", n)
 		}
-		return xsum.NewHashPlugin(alg, p), nil
+		if registry[k] != nil || seen[k] {
+			return fmt.Errorf("algorithm `%s' already registered", n)
+		}
+		seen[k] = true
+	}
+	if err := xsum.RegisterHashType(name, hashType); err != nil {
+		return err
 	}
+	for k := range seen {
+		registry[k] = fn
+	}
+	registered = append(registered, Algorithm{Name: name, Aliases: append([]string(nil), aliases...)})
+	return nil
 }
 
 func mustHash(hkf func([]byte) (hash.Hash, error)) func() hash.Hash {
-	if _, err := hkf(nil); err != nil {
+	hf, err := keyHash(hkf, nil)
+	if err != nil {
 		panic(err)
 	}
+	return hf
+}
+
+func keyHash(hkf func([]byte) (hash.Hash, error), key []byte) (func() hash.Hash, error) {
+	if _, err := hkf(key); err != nil {
+		return nil, err
+	}
 	return func() hash.Hash {
-		h, err := hkf(nil)
+		h, err := hkf(key)
 		if err != nil {
 			panic(err)
 		}
 		return h
+	}, nil
+}
+
+func keyedHash(name, alg string, key []byte, hkf func([]byte) (hash.Hash, error)) (xsum.Hash, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%w for algorithm `%s'", ErrKeyRequired, alg)
+	}
+	hf, err := keyHash(hkf, key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key for algorithm `%s': %w", alg, err)
+	}
+	return xsum.NewHashFunc(name, hf), nil
+}
+
+func hmacHash(hf func() hash.Hash) func([]byte) (hash.Hash, error) {
+	return func(key []byte) (hash.Hash, error) {
+		return hmac.New(hf, key), nil
 	}
 }
 
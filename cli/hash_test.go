@@ -0,0 +1,212 @@
+package cli_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"hash"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/cli"
+	"github.com/sclevine/xsum/encoding"
+)
+
+func TestRegisterHash(t *testing.T) {
+	if err := cli.RegisterHash("test-sha256", func([]byte) (xsum.Hash, error) {
+		return xsum.NewHashFunc("test-sha256", sha256.New), nil
+	}, encoding.HashSHA256, "test_sha2-256"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.RegisterHash("test-keyed", func(key []byte) (xsum.Hash, error) {
+		if len(key) == 0 {
+			return nil, cli.ErrKeyRequired
+		}
+		return xsum.NewHashFunc("test-keyed", func() hash.Hash {
+			h := sha256.New()
+			h.Write(key)
+			return h
+		}), nil
+	}, encoding.HashUnknown); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"test-sha256", "test_sha256", "test.sha2-256", "test-sha2/256@1k"} {
+		h, err := cli.ParseHash(name)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if s := h.String(); s != "test-sha256" && s != "test-sha256@1k" {
+			t.Errorf("%s: unexpected hash %s", name, s)
+		}
+	}
+	if _, err := cli.ParseHash("test-keyed"); !errors.Is(err, cli.ErrKeyRequired) {
+		t.Errorf("Expected key required, got: %v", err)
+	}
+	if _, err := cli.ParseKeyedHash("test-keyed", []byte("key")); err != nil {
+		t.Error(err)
+	}
+
+	// registered hash type is used for directories
+	sums := map[string][]byte{}
+	for _, name := range []string{"sha256", "test-sha256"} {
+		h, err := cli.ParseHash(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes, err := (&xsum.Sum{}).Find([]xsum.File{{Hash: h, Path: "../testdata/testdir"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sums[name] = nodes[0].Sum
+	}
+	if !bytes.Equal(sums["sha256"], sums["test-sha256"]) {
+		t.Errorf("Expected equal directory checksums, got %x and %x", sums["sha256"], sums["test-sha256"])
+	}
+
+	noop := func([]byte) (xsum.Hash, error) { return nil, nil }
+	for _, names := range [][]string{
+		{"sha256"},
+		{"other", "sha2_256"},
+		{"hmac-sha256"},
+		{"test-sha256"},
+		{"other", "test.sha256"},
+		{"other", "other2", "other2"},
+		{"bad:name"},
+		{"bad@name"},
+		{""},
+	} {
+		if err := cli.RegisterHash(names[0], noop, encoding.HashUnknown, names[1:]...); err == nil {
+			t.Errorf("%v: expected conflict", names)
+		}
+	}
+	if _, err := cli.ParseHash("other"); err == nil {
+		t.Error("Expected failed registration to have no effect")
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin discovery test requires executable bits")
+	}
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{
+		"xsum-foo":      0777,
+		"xsum-bar.wasm": 0666,
+		"xsum-sha256":   0777,
+		"xsum-notexec":  0666,
+		"xsum-bad@name": 0777,
+		"other-baz":     0777,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+
+	var plugins []cli.Algorithm
+	for _, alg := range cli.Algorithms() {
+		if alg.Path != "" {
+			plugins = append(plugins, alg)
+			continue
+		}
+		if !alg.Builtin {
+			continue
+		}
+		for _, name := range append([]string{alg.Name}, alg.Aliases...) {
+			h, err := cli.ParseKeyedHash(name, []byte("key"))
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				continue
+			}
+			if h.String() != alg.Name {
+				t.Errorf("%s: expected %s, got %s", name, alg.Name, h)
+			}
+		}
+		if _, err := cli.ParseHash(alg.Name); errors.Is(err, cli.ErrKeyRequired) != alg.Keyed {
+			t.Errorf("%s: unexpected keyed %t", alg.Name, alg.Keyed)
+		}
+	}
+	want := []cli.Algorithm{
+		{Name: "bar", Path: filepath.Join(dir, "xsum-bar.wasm")},
+		{Name: "foo", Path: filepath.Join(dir, "xsum-foo")},
+	}
+	if !reflect.DeepEqual(plugins, want) {
+		t.Errorf("Expected plugins %+v, got %+v", want, plugins)
+	}
+}
+
+func TestAlgorithms_aliases(t *testing.T) {
+	strip := strings.NewReplacer("-", "", "_", "", ".", "", "/", "").Replace
+	listed := map[string]string{}
+	for _, alg := range cli.Algorithms() {
+		if !alg.Builtin {
+			continue
+		}
+		for _, name := range append([]string{alg.Name}, alg.Aliases...) {
+			listed[strip(name)] = alg.Name
+		}
+	}
+
+	// every alias accepted by the builtinHash switch must be listed (up to separators) for the same hash function
+	f, err := parser.ParseFile(token.NewFileSet(), "hash.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var aliases []string
+	ast.Inspect(f, func(n ast.Node) bool {
+		if fn, ok := n.(*ast.FuncDecl); ok && fn.Name.Name != "builtinHash" {
+			return false
+		}
+		if cc, ok := n.(*ast.CaseClause); ok {
+			for _, e := range cc.List {
+				if lit, ok := e.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					alias, err := strconv.Unquote(lit.Value)
+					if err != nil {
+						t.Fatal(err)
+					}
+					aliases = append(aliases, alias)
+				}
+			}
+		}
+		return true
+	})
+	if len(aliases) == 0 {
+		t.Fatal("No aliases found in builtinHash")
+	}
+	for _, alias := range aliases {
+		h, err := cli.ParseKeyedHash(alias, []byte("key"))
+		if err != nil {
+			t.Errorf("%s: %s", alias, err)
+			continue
+		}
+		if name, ok := listed[strip(alias)]; !ok {
+			t.Errorf("%s: alias for %s not listed by Algorithms", alias, h)
+		} else if name != h.String() {
+			t.Errorf("%s: listed for %s, but parsed as %s", alias, name, h)
+		}
+	}
+}
+
+func TestWritePluginInfo(t *testing.T) {
+	hash, err := cli.ParseHash("md5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := cli.WritePluginInfo(&out, "/usr/local/bin/xsum-text-md5.exe", "1.0.0", hash); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); s != `{"name":"text-md5","version":"1.0.0","length":16,"types":["data","metadata"]}`+"\n" {
+		t.Errorf("Unexpected output: %s", s)
+	}
+}
@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/sclevine/xsum"
+)
+
+// Algorithm describes a hash function that may be selected by name.
+type Algorithm struct {
+	Name    string
+	Aliases []string
+
+	// Builtin is true for built-in hash functions.
+	Builtin bool
+
+	// Keyed is true for keyed hash functions (e.g., hmac-sha256).
+	Keyed bool
+
+	// Path is the path to the plugin file, for plugins.
+	Path string
+}
+
+// Algorithms returns all hash functions available to ParseHash:
+// built-in hash functions, followed by registered hash functions (see RegisterHash), followed by plugins found on $PATH.
+// Only plugins that are not shadowed by other hash functions or plugins are returned.
+// Plugins are not validated or executed.
+func Algorithms() []Algorithm {
+	var out []Algorithm
+	for _, a := range builtinAlgorithms {
+		_, err := builtinHash(a.Name, nil)
+		a.Builtin = true
+		a.Keyed = errors.Is(err, ErrKeyRequired)
+		out = append(out, a)
+	}
+	registryMu.RLock()
+	for _, a := range registered {
+		_, err := registry[toSingle(a.Name, "-", "_", ".", "/")](nil)
+		a.Keyed = errors.Is(err, ErrKeyRequired)
+		out = append(out, a)
+	}
+	registryMu.RUnlock()
+	return append(out, pluginAlgorithms()...)
+}
+
+func pluginAlgorithms() []Algorithm {
+	var out []Algorithm
+	seen := map[string]bool{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			alg, ok := pluginName(e.Name())
+			if !ok || seen[alg] {
+				continue
+			}
+			seen[alg] = true
+			if isBuiltinOrRegistered(alg) {
+				continue
+			}
+			if p, _, ok := lookPlugin(alg); ok {
+				out = append(out, Algorithm{Name: alg, Path: p})
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// pluginName returns the algorithm name for a plugin file name.
+func pluginName(file string) (alg string, ok bool) {
+	if !strings.HasPrefix(file, "xsum-") {
+		return "", false
+	}
+	alg = strings.TrimPrefix(file, "xsum-")
+	if strings.HasSuffix(alg, ".wasm") {
+		alg = strings.TrimSuffix(alg, ".wasm")
+	} else if runtime.GOOS == "windows" {
+		alg = strings.TrimSuffix(alg, filepath.Ext(alg))
+	}
+	if alg == "" || strings.ContainsAny(alg, ":@ \t\r\n") {
+		return "", false
+	}
+	return alg, true
+}
+
+func isBuiltinOrRegistered(alg string) bool {
+	if _, err := builtinHash(alg, nil); err != errUnknownHash {
+		return true
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return registry[toSingle(alg, "-", "_", ".", "/")] != nil
+}
+
+// PluginInfo returns the validated xsum.PluginInfo for a plugin returned by Algorithms.
+// PluginInfo returns nil (with no error) for plugins that do not support xsum.PluginInfoArg.
+func PluginInfo(alg Algorithm) (*xsum.PluginInfo, error) {
+	return cachedPlugin(alg.Name, alg.Path, strings.HasSuffix(alg.Path, ".wasm")).Info()
+}
+
+// WritePluginInfo writes the response of a plugin to xsum.PluginInfoArg as JSON.
+// The plugin name is determined from arg0 (i.e., xsum-[name]), and the checksum length is determined from hash.
+// Plugins are assumed to support all input types.
+func WritePluginInfo(w io.Writer, arg0, version string, hash xsum.Hash) error {
+	sum, err := hash.Metadata(nil)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(arg0)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	return json.NewEncoder(w).Encode(&xsum.PluginInfo{
+		Name:    strings.TrimPrefix(name, "xsum-"),
+		Version: version,
+		Length:  len(sum),
+		Types:   []string{xsum.PluginData, xsum.PluginMetadata},
+	})
+}
+
+// builtinAlgorithms lists the canonical names of built-in hash functions with common aliases.
+// Aliases that only differ by separators (-, _, ., /) are omitted.
+var builtinAlgorithms = []Algorithm{
+	{Name: xsum.HashMD4},
+	{Name: xsum.HashMD5},
+	{Name: xsum.HashSHA1},
+	{Name: xsum.HashSHA256, Aliases: []string{"sha2-256"}},
+	{Name: xsum.HashSHA224, Aliases: []string{"sha2-224"}},
+	{Name: xsum.HashSHA512, Aliases: []string{"sha2-512"}},
+	{Name: xsum.HashSHA384, Aliases: []string{"sha2-384"}},
+	{Name: xsum.HashSHA512_224, Aliases: []string{"sha2-512-224"}},
+	{Name: xsum.HashSHA512_256, Aliases: []string{"sha2-512-256"}},
+	{Name: xsum.HashSHA3_224},
+	{Name: xsum.HashSHA3_256},
+	{Name: xsum.HashSHA3_384},
+	{Name: xsum.HashSHA3_512},
+	{Name: xsum.HashBlake2s256, Aliases: []string{"b2s-256"}},
+	{Name: xsum.HashBlake2b256, Aliases: []string{"b2b-256"}},
+	{Name: xsum.HashBlake2b384, Aliases: []string{"b2b-384"}},
+	{Name: xsum.HashBlake2b512, Aliases: []string{"b2b-512"}},
+	{Name: xsum.HashRMD160, Aliases: []string{"ripemd-160"}},
+	{Name: xsum.HashSHA256Tree, Aliases: []string{"tree-hash"}},
+	{Name: xsum.HashHMACSHA256},
+	{Name: xsum.HashHMACSHA512},
+	{Name: xsum.HashBlake2s256Keyed, Aliases: []string{"b2s-256-keyed"}},
+	{Name: xsum.HashBlake2b256Keyed, Aliases: []string{"b2b-256-keyed"}},
+	{Name: xsum.HashBlake2b384Keyed, Aliases: []string{"b2b-384-keyed"}},
+	{Name: xsum.HashBlake2b512Keyed, Aliases: []string{"b2b-512-keyed"}},
+	{Name: xsum.HashCRC32, Aliases: []string{"crc32-ieee"}},
+	{Name: xsum.HashCRC32c, Aliases: []string{"crc32-castagnoli"}},
+	{Name: xsum.HashCRC32k, Aliases: []string{"crc32-koopman"}},
+	{Name: xsum.HashCRC64ISO},
+	{Name: xsum.HashCRC64ECMA},
+	{Name: xsum.HashAdler32},
+	{Name: xsum.HashFNV32},
+	{Name: xsum.HashFNV32a},
+	{Name: xsum.HashFNV64},
+	{Name: xsum.HashFNV64a},
+	{Name: xsum.HashFNV128},
+	{Name: xsum.HashFNV128a},
+}
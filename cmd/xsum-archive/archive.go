@@ -0,0 +1,302 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/encoding"
+)
+
+// Limits on the contents of an archive, which prevent archives from exhausting memory or running indefinitely.
+var (
+	maxEntries       = 1 << 20
+	maxSize    int64 = 1 << 40
+)
+
+var errTooLarge = errors.New("archive contents too large")
+
+// entry is a file, directory, or symlink in an archive.
+type entry struct {
+	mode    os.FileMode
+	sum     []byte            // file contents or symlink target
+	entries map[string]*entry // directory entries
+}
+
+// tree is an in-memory directory tree built from the entries of an archive.
+// Entries are hashed as they are read, so that their contents are never written to disk or held in memory.
+type tree struct {
+	hash     xsum.Hash
+	hashType encoding.HashType
+	root     *entry
+	entries  int
+	size     int64
+}
+
+func newTree(hash xsum.Hash) *tree {
+	return &tree{
+		hash:     hash,
+		hashType: xsum.LookupHashType(hash.String()),
+		root:     &entry{mode: os.ModeDir, entries: map[string]*entry{}},
+	}
+}
+
+// read adds the entries of a tar (optionally compressed with gzip or bzip2) or zip archive to the tree.
+func (t *tree) read(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(262)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, fi.Size())
+		if err != nil {
+			return err
+		}
+		return t.zip(zr)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		return t.tar(tar.NewReader(gr))
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return t.tar(tar.NewReader(bzip2.NewReader(br)))
+	case len(magic) == 262 && string(magic[257:]) == "ustar":
+		return t.tar(tar.NewReader(br))
+	}
+	return errors.New("unsupported archive format")
+}
+
+func (t *tree) tar(tr *tar.Reader) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+			err = t.file(hdr.Name, tr)
+		case tar.TypeDir:
+			err = t.mkdir(hdr.Name)
+		case tar.TypeSymlink:
+			err = t.symlink(hdr.Name, hdr.Linkname)
+		case tar.TypeLink:
+			err = t.hardlink(hdr.Name, hdr.Linkname)
+		case tar.TypeXGlobalHeader:
+		default:
+			err = fmt.Errorf("%s: unsupported entry type '%c'", hdr.Name, hdr.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *tree) zip(zr *zip.Reader) error {
+	for _, f := range zr.File {
+		mode := f.Mode()
+		if strings.HasSuffix(f.Name, "/") || mode.IsDir() {
+			if err := t.mkdir(f.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		if mode&^os.ModePerm != 0 && mode&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s: unsupported entry type", f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		if mode&os.ModeSymlink != 0 {
+			var target []byte
+			if target, err = io.ReadAll(&limitReader{rc, t}); err == nil {
+				err = t.symlink(f.Name, string(target))
+			}
+		} else {
+			err = t.file(f.Name, rc)
+		}
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// split returns the cleaned elements of an archive entry name.
+// Entry names that refer to locations outside the archive are rejected.
+func split(name string) ([]string, error) {
+	clean := path.Clean(strings.TrimLeft(name, "/"))
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return nil, fmt.Errorf("%s: unsafe path in archive", name)
+	}
+	if clean == "." {
+		return nil, nil
+	}
+	return strings.Split(clean, "/"), nil
+}
+
+// parent returns the directory containing an archive entry, creating any missing directories, and the base name of the entry.
+// The returned directory is nil for the root of the archive.
+func (t *tree) parent(name string) (*entry, string, error) {
+	elems, err := split(name)
+	if err != nil || len(elems) == 0 {
+		return nil, "", err
+	}
+	dir := t.root
+	for _, elem := range elems[:len(elems)-1] {
+		e, ok := dir.entries[elem]
+		if !ok {
+			if e, err = t.add(dir, elem, &entry{mode: os.ModeDir, entries: map[string]*entry{}}); err != nil {
+				return nil, "", err
+			}
+		} else if !e.mode.IsDir() {
+			return nil, "", fmt.Errorf("%s: path conflicts with entry in archive", name)
+		}
+		dir = e
+	}
+	return dir, elems[len(elems)-1], nil
+}
+
+func (t *tree) add(dir *entry, name string, e *entry) (*entry, error) {
+	if t.entries++; t.entries > maxEntries {
+		return nil, errors.New("too many entries in archive")
+	}
+	dir.entries[name] = e
+	return e, nil
+}
+
+// create adds a file or symlink to the tree.
+// Duplicate entries are rejected, because the contents of the archive would depend on the extraction order.
+func (t *tree) create(name string, e *entry) error {
+	dir, base, err := t.parent(name)
+	if err != nil {
+		return err
+	}
+	if dir == nil {
+		return fmt.Errorf("%s: invalid entry in archive", name)
+	}
+	if _, ok := dir.entries[base]; ok {
+		return fmt.Errorf("%s: duplicate entry in archive", name)
+	}
+	_, err = t.add(dir, base, e)
+	return err
+}
+
+func (t *tree) file(name string, r io.Reader) error {
+	sum, err := t.hash.Data(&limitReader{r, t})
+	if err != nil {
+		return err
+	}
+	return t.create(name, &entry{sum: sum})
+}
+
+// mkdir adds a directory to the tree.
+// Directories may be listed more than once, including after their contents.
+func (t *tree) mkdir(name string) error {
+	dir, base, err := t.parent(name)
+	if err != nil || dir == nil {
+		return err
+	}
+	if e, ok := dir.entries[base]; ok {
+		if !e.mode.IsDir() {
+			return fmt.Errorf("%s: duplicate entry in archive", name)
+		}
+		return nil
+	}
+	_, err = t.add(dir, base, &entry{mode: os.ModeDir, entries: map[string]*entry{}})
+	return err
+}
+
+// hardlink adds a copy of a file that was previously added to the tree.
+func (t *tree) hardlink(name, target string) error {
+	elems, err := split(target)
+	if err != nil {
+		return err
+	}
+	e := t.root
+	for _, elem := range elems {
+		if e = e.entries[elem]; e == nil {
+			return fmt.Errorf("%s: invalid link: %s not found", name, target)
+		}
+	}
+	if e.mode != 0 {
+		return fmt.Errorf("%s: invalid link: %s is not a regular file", name, target)
+	}
+	return t.create(name, &entry{sum: e.sum})
+}
+
+func (t *tree) symlink(name, target string) error {
+	sum, err := t.hash.Metadata([]byte(target))
+	if err != nil {
+		return err
+	}
+	return t.create(name, &entry{mode: os.ModeSymlink, sum: sum})
+}
+
+// sum returns the checksum of a directory, calculated the same way as xsum -d with mask 0000.
+func (t *tree) sum(dir *entry) ([]byte, error) {
+	hashes := make([]encoding.NamedHash, 0, len(dir.entries))
+	for name, e := range dir.entries {
+		sum := e.sum
+		if e.mode.IsDir() {
+			var err error
+			if sum, err = t.sum(e); err != nil {
+				return nil, err
+			}
+		}
+		der, err := encoding.FileASN1DER(t.hashType, sum, e.mode, os.ModeType, &encoding.Sys{})
+		if err != nil {
+			return nil, err
+		}
+		b, err := t.hash.Metadata(der)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, encoding.NamedHash{
+			Hash: b,
+			Name: []byte(name),
+		})
+	}
+	der, err := encoding.TreeASN1DER(t.hashType, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return t.hash.Metadata(der)
+}
+
+// limitReader fails once the total size of the contents of the archive exceeds maxSize.
+type limitReader struct {
+	r io.Reader
+	t *tree
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if l.t.size += int64(n); l.t.size > maxSize {
+		return n, errTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/cli"
+)
+
+var Version = "0.0.0"
+
+func main() {
+	alg := "sha256"
+	if name := filepath.Base(os.Args[0]); strings.HasPrefix(name, "xsum-archive-") {
+		alg = strings.TrimPrefix(strings.TrimSuffix(name, filepath.Ext(name)), "xsum-archive-")
+	}
+	hash, err := cli.ParseHash(alg)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	if len(os.Args) == 2 && os.Args[1] == xsum.PluginInfoArg {
+		if err := cli.WritePluginInfo(os.Stdout, os.Args[0], Version, hash); err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+		return
+	}
+
+	var out []byte
+	switch os.Getenv("XSUM_PLUGIN_TYPE") {
+	case "metadata":
+		r, err := input()
+		if err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+		defer r.Close()
+		out, err = hash.Data(r)
+		if err != nil {
+			r.Close()
+			log.Fatalf("Error: %s", err)
+		}
+	default: // "data"
+		path, temp, err := inputPath()
+		if err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+		out, err = archiveSum(path, hash)
+		if temp {
+			os.Remove(path)
+		}
+		if err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+	}
+	fmt.Printf("%x", out)
+}
+
+func input() (io.ReadCloser, error) {
+	switch len(os.Args) {
+	case 0, 1:
+		return io.NopCloser(os.Stdin), nil
+	case 2:
+		f, err := os.Open(os.Args[1])
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("extra arguments: %s", strings.Join(os.Args[2:], ", "))
+	}
+}
+
+// inputPath returns the path to the input file, and whether the file is temporary.
+// Archives provided via stdin are buffered to a temporary file, because zip archives must be read from the end.
+func inputPath() (path string, temp bool, err error) {
+	switch len(os.Args) {
+	case 0, 1:
+		path, err = bufferFile(os.Stdin)
+		return path, true, err
+	case 2:
+		return os.Args[1], false, nil
+	default:
+		return "", false, fmt.Errorf("extra arguments: %s", strings.Join(os.Args[2:], ", "))
+	}
+}
+
+func bufferFile(r io.Reader) (string, error) {
+	f, err := os.CreateTemp("", "xsum-archive-")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// archiveSum calculates the directory checksum of the contents of an archive, using mask 0000.
+// The checksum includes entry names, types, and contents, but not permissions, owners, timestamps, or entry order.
+// It is equal to the checksum of the extracted directory calculated with xsum -d.
+// Archives are not extracted, and archives with duplicate or conflicting entries are rejected.
+func archiveSum(path string, hash xsum.Hash) ([]byte, error) {
+	t := newTree(hash)
+	if err := t.read(path); err != nil {
+		return nil, err
+	}
+	return t.sum(t.root)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/cli"
+)
+
+type testEntry struct {
+	name, body string
+	flag       byte
+}
+
+var testEntries = []testEntry{
+	{name: "dir/", flag: tar.TypeDir},
+	{name: "dir/a.txt", body: "hello"},
+	{name: "dir/sub/b.txt", body: "world"},
+	{name: "dir/link", body: "a.txt", flag: tar.TypeSymlink},
+	{name: "dir/hard", body: "dir/a.txt", flag: tar.TypeLink},
+}
+
+func testTar(t *testing.T, entries []testEntry, mtime time.Time, mode int64) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, ModTime: mtime, Mode: mode, Typeflag: e.flag}
+		switch e.flag {
+		case tar.TypeSymlink, tar.TypeLink:
+			hdr.Linkname = e.body
+		case 0:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testZip(t *testing.T, entries []testEntry) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Modified: time.Unix(1e9, 0)}
+		switch e.flag {
+		case tar.TypeDir:
+			hdr.SetMode(os.ModeDir | 0755)
+		case tar.TypeSymlink:
+			hdr.SetMode(os.ModeSymlink | 0777)
+		case tar.TypeLink:
+			continue
+		default:
+			hdr.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := zw.Create("dir/hard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hello"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestArchiveSum(t *testing.T) {
+	hash, err := cli.ParseHash("sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+	sum := func(name string, b []byte) string {
+		t.Helper()
+		path := filepath.Join(tmp, name)
+		if err := os.WriteFile(path, b, 0666); err != nil {
+			t.Fatal(err)
+		}
+		out, err := archiveSum(path, hash)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		return string(out)
+	}
+
+	dir := filepath.Join(tmp, "expected")
+	if err := os.MkdirAll(filepath.Join(dir, "dir", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{"a.txt": "hello", "hard": "hello", "sub/b.txt": "world"} {
+		if err := os.WriteFile(filepath.Join(dir, "dir", name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(dir, "dir", "link")); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := (&xsum.Sum{}).Find([]xsum.File{{Hash: hash, Path: dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := string(nodes[0].Sum)
+
+	// different order, without directory entry
+	shuffled := []testEntry{testEntries[2], testEntries[3], testEntries[1], testEntries[4]}
+	tgz := &bytes.Buffer{}
+	gw := gzip.NewWriter(tgz)
+	gw.Write(testTar(t, shuffled, time.Unix(2e9, 0), 0600))
+	gw.Close()
+
+	for name, b := range map[string][]byte{
+		"test.tar":    testTar(t, testEntries, time.Unix(1e9, 0), 0644),
+		"test.tar.gz": tgz.Bytes(),
+		"test.zip":    testZip(t, testEntries),
+	} {
+		if out := sum(name, b); out != expected {
+			t.Errorf("%s: expected %x, got %x", name, expected, out)
+		}
+	}
+
+	changed := append([]testEntry{}, testEntries...)
+	changed[1].body = "hellO"
+	if out := sum("changed.tar", testTar(t, changed, time.Unix(1e9, 0), 0644)); out == expected {
+		t.Error("Expected changed contents to change checksum")
+	}
+}
+
+func TestArchiveSumInvalid(t *testing.T) {
+	hash, err := cli.ParseHash("sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+	for name, entries := range map[string][]testEntry{
+		"parent": {{name: "../escape", body: "x"}},
+		"link": {
+			{name: "out", body: tmp, flag: tar.TypeSymlink},
+			{name: "out/escape", body: "x", flag: tar.TypeSymlink},
+		},
+		"device":    {{name: "dev", flag: tar.TypeChar}},
+		"duplicate": {{name: "a", body: "x"}, {name: "./a", body: "y"}},
+		"file-dir":  {{name: "a/b", body: "x"}, {name: "a", body: "y"}},
+		"dir-file":  {{name: "a", body: "x"}, {name: "a/", flag: tar.TypeDir}},
+		"parent-file": {
+			{name: "a", body: "x"},
+			{name: "a/b", body: "y"},
+		},
+		"hardlink-missing": {{name: "a", body: "b", flag: tar.TypeLink}},
+		"hardlink-dir": {
+			{name: "a/", flag: tar.TypeDir},
+			{name: "b", body: "a", flag: tar.TypeLink},
+		},
+	} {
+		path := filepath.Join(tmp, name+".tar")
+		if err := os.WriteFile(path, testTar(t, entries, time.Unix(1e9, 0), 0644), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := archiveSum(path, hash); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// repeated directories are permitted
+	path := filepath.Join(tmp, "dirs.tar")
+	if err := os.WriteFile(path, testTar(t, []testEntry{
+		{name: "./", flag: tar.TypeDir},
+		{name: "a/b", body: "x"},
+		{name: "a/", flag: tar.TypeDir},
+		{name: "a/", flag: tar.TypeDir},
+	}, time.Unix(1e9, 0), 0644), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archiveSum(path, hash); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestArchiveSumLimits(t *testing.T) {
+	hash, err := cli.ParseHash("sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(entries int, size int64) {
+		maxEntries, maxSize = entries, size
+	}(maxEntries, maxSize)
+	path := filepath.Join(t.TempDir(), "test.tar")
+	if err := os.WriteFile(path, testTar(t, testEntries, time.Unix(1e9, 0), 0644), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	maxEntries, maxSize = 6, 10
+	if _, err := archiveSum(path, hash); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	maxEntries, maxSize = 5, 10
+	if _, err := archiveSum(path, hash); err == nil || !strings.Contains(err.Error(), "too many entries") {
+		t.Errorf("Expected entry limit, got: %v", err)
+	}
+	maxEntries, maxSize = 6, 9
+	if _, err := archiveSum(path, hash); err != errTooLarge {
+		t.Errorf("Expected size limit, got: %v", err)
+	}
+}
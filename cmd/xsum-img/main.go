@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+
+	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/cli"
+)
+
+var Version = "0.0.0"
+
+func main() {
+	alg := "sha256"
+	if name := filepath.Base(os.Args[0]); strings.HasPrefix(name, "xsum-img-") {
+		alg = strings.TrimPrefix(strings.TrimSuffix(name, filepath.Ext(name)), "xsum-img-")
+	}
+	hash, err := cli.ParseHash(alg)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	if len(os.Args) == 2 && os.Args[1] == xsum.PluginInfoArg {
+		if err := cli.WritePluginInfo(os.Stdout, os.Args[0], Version, hash); err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+		return
+	}
+	r, err := input()
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	defer r.Close()
+
+	var out []byte
+	switch os.Getenv("XSUM_PLUGIN_TYPE") {
+	case "metadata":
+		out, err = hash.Data(r)
+	default: // "data"
+		out, err = imageSum(r, hash)
+	}
+	if err != nil {
+		r.Close()
+		log.Fatalf("Error: %s", err)
+	}
+	fmt.Printf("%x", out)
+}
+
+func input() (io.ReadCloser, error) {
+	switch len(os.Args) {
+	case 0, 1:
+		return io.NopCloser(os.Stdin), nil
+	case 2:
+		f, err := os.Open(os.Args[1])
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("extra arguments: %s", strings.Join(os.Args[2:], ", "))
+	}
+}
+
+// imageSum decodes an image and hashes its canonical pixel stream.
+// All frames of animated GIFs are included.
+func imageSum(r io.Reader, hash xsum.Hash) ([]byte, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(6)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %w", err)
+	}
+	var frames []image.Image
+	if string(magic) == "GIF87a" || string(magic) == "GIF89a" {
+		g, err := gif.DecodeAll(br)
+		if err != nil {
+			return nil, err
+		}
+		for _, frame := range g.Image {
+			frames = append(frames, frame)
+		}
+	} else {
+		img, _, err := image.Decode(br)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, img)
+	}
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(writePixels(pw, frames))
+	}()
+	return hash.Data(pr)
+}
+
+// writePixels writes the canonical pixel stream of each frame:
+// the frame bounds as four big-endian int32 values (min x, min y, max x, max y),
+// followed by each pixel in row-major order as non-premultiplied, 16-bit big-endian R, G, B, A values.
+func writePixels(w io.Writer, frames []image.Image) error {
+	bw := bufio.NewWriter(w)
+	px := make([]byte, 8)
+	for _, img := range frames {
+		b := img.Bounds()
+		if err := binary.Write(bw, binary.BigEndian, []int32{
+			int32(b.Min.X), int32(b.Min.Y), int32(b.Max.X), int32(b.Max.Y),
+		}); err != nil {
+			return err
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+				binary.BigEndian.PutUint16(px[0:], c.R)
+				binary.BigEndian.PutUint16(px[2:], c.G)
+				binary.BigEndian.PutUint16(px[4:], c.B)
+				binary.BigEndian.PutUint16(px[6:], c.A)
+				if _, err := bw.Write(px); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/bmp"
+
+	"github.com/sclevine/xsum/cli"
+)
+
+func TestImageSum(t *testing.T) {
+	hash, err := cli.ParseHash("sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 31, 17))
+	for y := 0; y < 17; y++ {
+		for x := 0; x < 31; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 8), G: uint8(y * 15), B: uint8(x ^ y), A: 255})
+		}
+	}
+	sum := func(b []byte) string {
+		out, err := imageSum(bytes.NewReader(b), hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+
+	pngData := &bytes.Buffer{}
+	if err := png.Encode(pngData, img); err != nil {
+		t.Fatal(err)
+	}
+	bmpData := &bytes.Buffer{}
+	if err := bmp.Encode(bmpData, img); err != nil {
+		t.Fatal(err)
+	}
+	expected := sum(pngData.Bytes())
+	if sum(bmpData.Bytes()) != expected {
+		t.Error("Expected PNG and BMP checksums to match")
+	}
+	if sum(withPNGText(pngData.Bytes(), "Comment", "edited")) != expected {
+		t.Error("Expected PNG checksum to ignore text metadata")
+	}
+
+	jpegData := &bytes.Buffer{}
+	if err := jpeg.Encode(jpegData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	b := jpegData.Bytes()
+	comment := append([]byte{0xff, 0xfe, 0, 8}, "edited"...)
+	edited := append(append(append([]byte{}, b[:2]...), comment...), b[2:]...)
+	if sum(edited) != sum(b) {
+		t.Error("Expected JPEG checksum to ignore comments")
+	}
+
+	img.Set(1, 1, color.NRGBA{R: 255, B: 255, A: 255})
+	changed := &bytes.Buffer{}
+	if err := png.Encode(changed, img); err != nil {
+		t.Fatal(err)
+	}
+	if sum(changed.Bytes()) == expected {
+		t.Error("Expected checksum to change with pixel data")
+	}
+}
+
+// withPNGText inserts a tEXt chunk after the IHDR chunk
+func withPNGText(b []byte, key, value string) []byte {
+	const ihdrEnd = 8 + 8 + 13 + 4
+	data := append([]byte("tEXt"+key+"\x00"), value...)
+	chunk := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)-4))
+	chunk = append(chunk, data...)
+	chunk = append(chunk, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(chunk[len(chunk)-4:], crc32.ChecksumIEEE(data))
+	return append(append(append([]byte{}, b[:ihdrEnd]...), chunk...), b[ihdrEnd:]...)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/cli"
+)
+
+var Version = "0.0.0"
+
+var bom = []byte{0xef, 0xbb, 0xbf}
+
+func main() {
+	strict, alg := parseName(os.Args[0])
+	hash, err := cli.ParseHash(alg)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	if len(os.Args) == 2 && os.Args[1] == xsum.PluginInfoArg {
+		if err := cli.WritePluginInfo(os.Stdout, os.Args[0], Version, hash); err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+		return
+	}
+	r, err := input()
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	defer r.Close()
+	name := "standard input"
+	if len(os.Args) == 2 {
+		name = os.Args[1]
+	}
+
+	var out []byte
+	switch os.Getenv("XSUM_PLUGIN_TYPE") {
+	case "metadata":
+		out, err = hash.Data(r)
+	default: // "data"
+		out, err = dataSum(r, hash, strict, name)
+	}
+	if err != nil {
+		r.Close()
+		log.Fatalf("Error: %s", err)
+	}
+	fmt.Printf("%x", out)
+}
+
+// parseName determines the plugin variant from its file name.
+// The name xsum-json[-strict][-<alg>] selects whether invalid input is an error and the inner algorithm.
+func parseName(arg0 string) (strict bool, alg string) {
+	name := filepath.Base(arg0)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = strings.TrimPrefix(name, "xsum-json")
+	if strings.HasPrefix(name, "-strict") {
+		strict = true
+		name = strings.TrimPrefix(name, "-strict")
+	}
+	if strings.HasPrefix(name, "-") {
+		return strict, name[1:]
+	}
+	return strict, "sha256"
+}
+
+func input() (io.ReadCloser, error) {
+	switch len(os.Args) {
+	case 0, 1:
+		return io.NopCloser(os.Stdin), nil
+	case 2:
+		f, err := os.Open(os.Args[1])
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("extra arguments: %s", strings.Join(os.Args[2:], ", "))
+	}
+}
+
+// warn writes a warning in the form specified by the plugin interface.
+func warn(category, format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "warning: %s: %s\n", category, fmt.Sprintf(format, a...))
+}
+
+// dataSum hashes the canonical form of JSON or YAML data.
+// Unless strict is true, invalid data is hashed unmodified.
+func dataSum(r io.Reader, hash xsum.Hash, strict bool, name string) ([]byte, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c, err := canonicalize(b)
+	if err != nil {
+		if strict {
+			return nil, err
+		}
+		warn("fallback", "hashing '%s' unmodified: %s", name, err)
+		c = b
+	}
+	return hash.Data(bytes.NewReader(c))
+}
+
+// canonicalize converts a JSON value or YAML document to RFC 8785 canonical JSON.
+func canonicalize(b []byte) ([]byte, error) {
+	if !utf8.Valid(b) {
+		return nil, errors.New("invalid UTF-8")
+	}
+	b = bytes.TrimPrefix(b, bom)
+	v, err := parseJSON(b)
+	if err != nil {
+		yv, yErr := parseYAML(b)
+		if yErr != nil {
+			if t := bytes.TrimLeft(b, " \t\r\n"); len(t) > 0 && (t[0] == '{' || t[0] == '[') {
+				return nil, fmt.Errorf("invalid JSON: %w", err)
+			}
+			return nil, yErr
+		}
+		v = yv
+	}
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
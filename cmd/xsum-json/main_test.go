@@ -0,0 +1,143 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestFormatNumber(t *testing.T) {
+	// examples from RFC 8785, Appendix B
+	for f, s := range map[float64]string{
+		0:                        "0",
+		math.Copysign(0, -1):     "0",
+		math.Float64frombits(1):  "5e-324",
+		-math.Float64frombits(1): "-5e-324",
+		math.MaxFloat64:          "1.7976931348623157e+308",
+		9007199254740992:         "9007199254740992",
+		-9007199254740992:        "-9007199254740992",
+		295147905179352830000:    "295147905179352830000",
+		9.999999999999997e+22:    "9.999999999999997e+22",
+		1e+23:                    "1e+23",
+		1e21:                     "1e+21",
+		999999999999999700000:    "999999999999999700000",
+		0.000001:                 "0.000001",
+		1e-7:                     "1e-7",
+		333333333.3333333:        "333333333.3333333",
+		-1.5:                     "-1.5",
+	} {
+		if out := formatNumber(f); out != s {
+			t.Errorf("formatNumber(%g): expected %s, got %s", f, s, out)
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	const expected = `{"":null,"a":[1,2.5,true,false,"x\n\"y\"\u001f€"],"b":{"c":"d"},"é":1,"😀":0.000001}`
+	for name, in := range map[string]string{
+		"canonical": expected,
+		"json": "\xef\xbb\xbf{\r\n" +
+			`  "😀": 1E-6, "é": 1.0,` + "\n" +
+			`  "b": {"c": "d"},` + "\n" +
+			`  "a": [1, 25e-1, true, false, "x\n\"y\"\u001F\u20ac"], "": null` + "\n" +
+			"}\n",
+		"yaml block": "---\n" +
+			"# comment\n" +
+			"b:\n" +
+			"  c: d # comment\n" +
+			"a:\n" +
+			"- 0x1\n" +
+			"- 2.50\n" +
+			"- True\n" +
+			"- false\n" +
+			"- \"x\\n\\\"y\\\"\\x1f\\u20ac\"\n" +
+			"'😀': 0.000001\n" +
+			"é: +1\n" +
+			"\"\": ~\n",
+		"yaml flow": "{b: {c: d}, a: [1, 2.5, true, false, 'x\n\"y\"\x1f€'], 😀: 1e-6, é: 1, '':}\n",
+	} {
+		if name == "yaml flow" {
+			// raw newlines are not permitted in single-line flow scalars
+			in = strings.Replace(in, "'x\n\"y\"\x1f€'", "\"x\\n\\\"y\\\"\\x1f€\"", 1)
+		}
+		out, err := canonicalize([]byte(in))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if string(out) != expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", name, expected, out)
+		}
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	for _, tt := range []struct {
+		in, out string
+	}{
+		{"- a\n- - b\n  - c\n- d: e\n  f: g\n-\n", `["a",["b","c"],{"d":"e","f":"g"},null]`},
+		{"a:\n- 1\n- 2\nb: c\n", `{"a":[1,2],"b":"c"}`},
+		{"a:\n  b:\n    c: x y:z\n  d: 'it''s'\n", `{"a":{"b":{"c":"x y:z"},"d":"it's"}}`},
+		{"url: http://example.com/#x\nempty:\nnum: 0o17\n", `{"empty":null,"num":15,"url":"http://example.com/#x"}`},
+		{"a: |\n  one\n   two\n\n  three\nb: end\n", `{"a":"one\n two\n\nthree\n","b":"end"}`},
+		{"a: >-\n  one\n  two\n\n  three\n    four\n  five\n", `{"a":"one two\nthree\n  four\nfive"}`},
+		{"a: |+\n  x\n\n", `{"a":"x\n\n"}`},
+		{"- [a, [b, c], {d: 1, e}, ]\n", `[["a",["b","c"],{"d":1,"e":null}]]`},
+		{"a: 1\n...\n", `{"a":1}`},
+	} {
+		v, err := parseYAML([]byte(tt.in))
+		if err != nil {
+			t.Errorf("parseYAML(%q): %s", tt.in, err)
+			continue
+		}
+		out, err := canonicalize([]byte(tt.out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c, err := canonicalize([]byte(tt.in)); err != nil || string(c) != string(out) {
+			t.Errorf("parseYAML(%q): expected %s, got %s (%v, %v)", tt.in, out, c, v, err)
+		}
+	}
+}
+
+func TestCanonicalizeInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"plain text\n",
+		"{\"a\": 1",
+		"{\"a\": 1, \"a\": 2}",
+		"[1e400]",
+		"\"\xff\"",
+		"a: 1\na: 2\n",
+		"a: &x 1\nb: *x\n",
+		"a: !!str 1\n",
+		"a: 1\n---\nb: 2\n",
+		"a: [1,\n  2]\n",
+		"a: one\n  two\n",
+		"a: .inf\n",
+		"a:\n\tb: 1\n",
+		"%YAML 1.2\n---\na: 1\n",
+	} {
+		if out, err := canonicalize([]byte(in)); err == nil {
+			t.Errorf("canonicalize(%q): expected error, got %s", in, out)
+		}
+	}
+}
+
+func TestParseName(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		strict bool
+		alg    string
+	}{
+		{"xsum-json", false, "sha256"},
+		{"/usr/local/bin/xsum-json-md5", false, "md5"},
+		{"xsum-json-strict", true, "sha256"},
+		{"bin/xsum-json-strict-sha512-256.exe", true, "sha512-256"},
+	} {
+		strict, alg := parseName(tt.name)
+		if strict != tt.strict || alg != tt.alg {
+			t.Errorf("parseName(%q): expected %t, %s, got %t, %s", tt.name, tt.strict, tt.alg, strict, alg)
+		}
+	}
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The YAML parser supports the subset of YAML 1.2 commonly used for configuration files:
+// block and flow collections, plain and quoted scalars, literal and folded block scalars, and comments.
+// Anchors, aliases, tags, directives, multi-line flow or quoted scalars, and multiple documents are rejected.
+// Scalars are resolved using the YAML 1.2 core schema.
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlOct   = regexp.MustCompile(`^0o[0-7]+$`)
+	yamlHex   = regexp.MustCompile(`^0x[0-9a-fA-F]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+	yamlInf   = regexp.MustCompile(`^([-+]?\.(inf|Inf|INF)|\.(nan|NaN|NAN))$`)
+)
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string // without indentation
+	raw    string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+// parseYAML parses a YAML document containing a mapping or sequence.
+func parseYAML(b []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		raw = strings.TrimSuffix(raw, "\r")
+		text := strings.TrimLeft(raw, " ")
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(raw) - len(text), text: text, raw: raw})
+	}
+	if !p.skip() {
+		return nil, fmt.Errorf("invalid YAML: empty document")
+	}
+	if l := p.line(); l.indent == 0 && strings.HasPrefix(l.text, "%") {
+		return nil, p.errorf("directives are not supported")
+	} else if l.indent == 0 && l.text == "---" {
+		p.i++
+		if !p.skip() {
+			return nil, fmt.Errorf("invalid YAML: empty document")
+		}
+	}
+	l := p.line()
+	if !isSeqItem(l.text) && !isMapLine(l.text) && !strings.HasPrefix(l.text, "[") && !strings.HasPrefix(l.text, "{") {
+		return nil, p.errorf("document must contain a mapping or sequence")
+	}
+	v, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	if p.skip() && isDocMarker(p.line()) && p.line().text == "..." {
+		p.i++
+	}
+	if p.skip() {
+		if p.line().text == "---" {
+			return nil, p.errorf("multiple documents are not supported")
+		}
+		return nil, p.errorf("unexpected content")
+	}
+	return v, nil
+}
+
+func (p *yamlParser) line() yamlLine {
+	return p.lines[p.i]
+}
+
+func (p *yamlParser) errorf(format string, a ...interface{}) error {
+	num := len(p.lines)
+	if p.i < len(p.lines) {
+		num = p.lines[p.i].num
+	}
+	return fmt.Errorf("invalid YAML: line %d: %s", num, fmt.Sprintf(format, a...))
+}
+
+// skip advances past blank and comment lines, and returns false if no lines remain.
+func (p *yamlParser) skip() bool {
+	for ; p.i < len(p.lines); p.i++ {
+		if t := strings.TrimLeft(p.lines[p.i].text, " \t"); t != "" && t[0] != '#' {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBlock parses the node starting on the current line.
+func (p *yamlParser) parseBlock() (interface{}, error) {
+	l := p.line()
+	switch {
+	case strings.HasPrefix(l.text, "\t"):
+		return nil, p.errorf("tabs are not allowed in indentation")
+	case isSeqItem(l.text):
+		return p.parseSeq(l.indent)
+	case isMapLine(l.text):
+		return p.parseMap(l.indent)
+	}
+	v, err := p.parseInline(l.text)
+	if err != nil {
+		return nil, err
+	}
+	p.i++
+	return v, nil
+}
+
+func (p *yamlParser) parseSeq(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	for p.skip() {
+		l := p.line()
+		if l.indent < indent || (l.indent == indent && !isSeqItem(l.text)) || isDocMarker(l) {
+			break
+		} else if strings.HasPrefix(l.text, "\t") {
+			return nil, p.errorf("tabs are not allowed in indentation")
+		} else if l.indent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		rest := strings.TrimLeft(l.text[1:], " ")
+		if rest != "" && (isSeqItem(rest) || isMapLine(rest)) {
+			// compact nested collection, e.g., "- key: value"
+			p.lines[p.i] = yamlLine{num: l.num, indent: l.indent + len(l.text) - len(rest), text: rest, raw: l.raw}
+			v, err := p.parseBlock()
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			continue
+		}
+		v, err := p.parseValue(rest, indent, false)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+	}
+	return seq, nil
+}
+
+func (p *yamlParser) parseMap(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.skip() {
+		l := p.line()
+		if l.indent < indent || isDocMarker(l) {
+			break
+		} else if strings.HasPrefix(l.text, "\t") {
+			return nil, p.errorf("tabs are not allowed in indentation")
+		} else if l.indent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		key, rest, ok, err := splitKey(l.text)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		} else if !ok {
+			return nil, p.errorf("expected mapping key")
+		}
+		if _, ok := m[key]; ok {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		if m[key], err = p.parseValue(rest, indent, true); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// parseValue parses the value following a mapping key or sequence indicator on the current line.
+func (p *yamlParser) parseValue(rest string, indent int, inMap bool) (interface{}, error) {
+	rest = strings.TrimLeft(rest, " ")
+	if rest == "" || rest[0] == '#' {
+		p.i++
+		if !p.skip() {
+			return nil, nil
+		}
+		if l := p.line(); l.indent > indent || (inMap && l.indent == indent && isSeqItem(l.text)) {
+			return p.parseBlock()
+		}
+		return nil, nil
+	}
+	if rest[0] == '|' || rest[0] == '>' {
+		return p.parseBlockScalar(rest, indent)
+	}
+	v, err := p.parseInline(rest)
+	if err != nil {
+		return nil, err
+	}
+	p.i++
+	if p.skip() && p.line().indent > indent {
+		return nil, p.errorf("multi-line plain scalars are not supported")
+	}
+	return v, nil
+}
+
+// parseInline parses a value that is contained in s, followed by an optional comment.
+func (p *yamlParser) parseInline(s string) (interface{}, error) {
+	v, n, err := scanValue(s, false)
+	if err != nil {
+		return nil, p.errorf("%s", err)
+	}
+	if rest := strings.TrimLeft(s[n:], " "); rest != "" && rest[0] != '#' {
+		return nil, p.errorf("unexpected %q", rest)
+	}
+	return v, nil
+}
+
+func (p *yamlParser) parseBlockScalar(header string, indent int) (interface{}, error) {
+	style, chomp, explicit := header[0], byte(0), 0
+	i := 1
+	for ; i < len(header) && header[i] != ' '; i++ {
+		switch c := header[i]; {
+		case (c == '-' || c == '+') && chomp == 0:
+			chomp = c
+		case c >= '1' && c <= '9' && explicit == 0:
+			explicit = int(c - '0')
+		default:
+			return nil, p.errorf("invalid block scalar header %q", header)
+		}
+	}
+	if rest := strings.TrimLeft(header[i:], " "); rest != "" && rest[0] != '#' {
+		return nil, p.errorf("invalid block scalar header %q", header)
+	}
+	p.i++
+
+	content := -1
+	if explicit > 0 {
+		content = indent + explicit
+	}
+	var lines []string
+	for ; p.i < len(p.lines); p.i++ {
+		l := p.lines[p.i]
+		if strings.TrimLeft(l.raw, " ") == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if content < 0 {
+			if l.indent <= indent {
+				break
+			}
+			content = l.indent
+		}
+		if l.indent < content {
+			break
+		}
+		lines = append(lines, l.raw[content:])
+	}
+
+	end := len(lines)
+	for end > 0 && lines[end-1] == "" {
+		end--
+	}
+	trailing := len(lines) - end
+	var (
+		out        strings.Builder
+		breaks     int
+		started    bool
+		prevNormal bool
+	)
+	for _, l := range lines[:end] {
+		if l == "" {
+			breaks++
+			continue
+		}
+		more := l[0] == ' ' || l[0] == '\t'
+		switch {
+		case !started:
+			out.WriteString(strings.Repeat("\n", breaks))
+		case style == '>' && prevNormal && !more && breaks == 0:
+			out.WriteByte(' ')
+		case style == '>' && prevNormal && !more:
+			out.WriteString(strings.Repeat("\n", breaks))
+		default:
+			out.WriteString(strings.Repeat("\n", breaks+1))
+		}
+		out.WriteString(l)
+		breaks, started, prevNormal = 0, true, !more
+	}
+	switch {
+	case chomp == '+' && started:
+		out.WriteString(strings.Repeat("\n", trailing+1))
+	case chomp == '+':
+		out.WriteString(strings.Repeat("\n", trailing))
+	case chomp == 0 && started:
+		out.WriteByte('\n')
+	}
+	return out.String(), nil
+}
+
+func isDocMarker(l yamlLine) bool {
+	return l.indent == 0 && (l.text == "---" || l.text == "...")
+}
+
+func isSeqItem(s string) bool {
+	return s == "-" || strings.HasPrefix(s, "- ")
+}
+
+func isMapLine(s string) bool {
+	_, _, ok, err := splitKey(s)
+	return ok || err != nil
+}
+
+// splitKey splits a block mapping entry into its key and the remaining text after the colon.
+func splitKey(s string) (key, rest string, ok bool, err error) {
+	if s == "" {
+		return "", "", false, nil
+	}
+	if s[0] == '"' || s[0] == '\'' {
+		k, n, err := scanQuoted(s)
+		if err != nil {
+			return "", "", false, nil
+		}
+		r := strings.TrimLeft(s[n:], " ")
+		if r == ":" || strings.HasPrefix(r, ": ") {
+			return k, r[1:], true, nil
+		}
+		return "", "", false, nil
+	}
+	if strings.IndexByte("[{#", s[0]) >= 0 || isSeqItem(s) {
+		return "", "", false, nil
+	}
+	n := plainEnd(s, false)
+	if n == len(s) || s[n] != ':' {
+		return "", "", false, nil
+	}
+	key = strings.TrimRight(s[:n], " ")
+	if key == "" || strings.IndexByte("&*!?|>%@`", key[0]) >= 0 {
+		return "", "", false, fmt.Errorf("unsupported mapping key %q", key)
+	}
+	return key, s[n+1:], true, nil
+}
+
+// plainEnd returns the length of the plain scalar at the start of s.
+func plainEnd(s string, flow bool) int {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '#' && i > 0 && s[i-1] == ' ':
+			return i
+		case flow && (c == ',' || c == ']' || c == '}'):
+			return i
+		case c == ':' && (i+1 == len(s) || s[i+1] == ' ' || (flow && strings.IndexByte(",]}", s[i+1]) >= 0)):
+			return i
+		}
+	}
+	return len(s)
+}
+
+// scanValue parses the scalar or flow collection at the start of s, and returns the number of bytes consumed.
+func scanValue(s string, flow bool) (v interface{}, n int, err error) {
+	if s == "" {
+		return nil, 0, fmt.Errorf("missing value")
+	}
+	switch c := s[0]; {
+	case c == '"' || c == '\'':
+		v, n, err := scanQuoted(s)
+		return v, n, err
+	case c == '[':
+		return scanFlowSeq(s)
+	case c == '{':
+		return scanFlowMap(s)
+	case strings.IndexByte("&*!|>%@`?", c) >= 0:
+		return nil, 0, fmt.Errorf("unsupported syntax %q", s)
+	case strings.IndexByte(",]}#", c) >= 0 || isSeqItem(s):
+		return nil, 0, fmt.Errorf("unexpected %q", s)
+	}
+	n = plainEnd(s, flow)
+	v, err = resolve(strings.TrimRight(s[:n], " "))
+	return v, n, err
+}
+
+func scanFlowSeq(s string) (interface{}, int, error) {
+	seq := []interface{}{}
+	i := 1
+	for {
+		i += len(s[i:]) - len(strings.TrimLeft(s[i:], " "))
+		if i == len(s) {
+			return nil, 0, fmt.Errorf("multi-line flow sequences are not supported")
+		}
+		if s[i] == ']' {
+			return seq, i + 1, nil
+		}
+		v, n, err := scanValue(s[i:], true)
+		if err != nil {
+			return nil, 0, err
+		}
+		seq = append(seq, v)
+		i += n
+		i += len(s[i:]) - len(strings.TrimLeft(s[i:], " "))
+		if i < len(s) && s[i] == ',' {
+			i++
+		} else if i == len(s) || s[i] != ']' {
+			return nil, 0, fmt.Errorf("multi-line flow sequences are not supported")
+		}
+	}
+}
+
+func scanFlowMap(s string) (interface{}, int, error) {
+	m := map[string]interface{}{}
+	i := 1
+	for {
+		i += len(s[i:]) - len(strings.TrimLeft(s[i:], " "))
+		if i == len(s) {
+			return nil, 0, fmt.Errorf("multi-line flow mappings are not supported")
+		}
+		if s[i] == '}' {
+			return m, i + 1, nil
+		}
+		var key string
+		if s[i] == '"' || s[i] == '\'' {
+			k, n, err := scanQuoted(s[i:])
+			if err != nil {
+				return nil, 0, err
+			}
+			key = k
+			i += n
+		} else {
+			n := plainEnd(s[i:], true)
+			key = strings.TrimRight(s[i:i+n], " ")
+			if key == "" || strings.IndexByte("&*!?|>%@`[{", key[0]) >= 0 {
+				return nil, 0, fmt.Errorf("unsupported mapping key %q", key)
+			}
+			i += n
+		}
+		if _, ok := m[key]; ok {
+			return nil, 0, fmt.Errorf("duplicate key %q", key)
+		}
+		i += len(s[i:]) - len(strings.TrimLeft(s[i:], " "))
+		m[key] = nil
+		if i < len(s) && s[i] == ':' {
+			i++
+			i += len(s[i:]) - len(strings.TrimLeft(s[i:], " "))
+			if i < len(s) && s[i] != ',' && s[i] != '}' {
+				v, n, err := scanValue(s[i:], true)
+				if err != nil {
+					return nil, 0, err
+				}
+				m[key] = v
+				i += n
+				i += len(s[i:]) - len(strings.TrimLeft(s[i:], " "))
+			}
+		}
+		if i < len(s) && s[i] == ',' {
+			i++
+		} else if i == len(s) || s[i] != '}' {
+			return nil, 0, fmt.Errorf("multi-line flow mappings are not supported")
+		}
+	}
+}
+
+// scanQuoted parses the single- or double-quoted scalar at the start of s.
+func scanQuoted(s string) (string, int, error) {
+	var out strings.Builder
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == q && q == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			out.WriteByte('\'')
+			i++
+		case c == q:
+			return out.String(), i + 1, nil
+		case c == '\\' && q == '"':
+			if i+1 == len(s) {
+				return "", 0, fmt.Errorf("multi-line quoted scalars are not supported")
+			}
+			i++
+			if r, ok := yamlEscapes[s[i]]; ok {
+				out.WriteRune(r)
+				continue
+			}
+			var size int
+			switch s[i] {
+			case 'x':
+				size = 2
+			case 'u':
+				size = 4
+			case 'U':
+				size = 8
+			}
+			if size == 0 || i+size >= len(s) {
+				return "", 0, fmt.Errorf("invalid escape sequence in %s", s)
+			}
+			r, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(r)) {
+				return "", 0, fmt.Errorf("invalid escape sequence in %s", s)
+			}
+			out.WriteRune(rune(r))
+			i += size
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("multi-line quoted scalars are not supported")
+}
+
+var yamlEscapes = map[byte]rune{
+	'0': 0, 'a': '\a', 'b': '\b', 't': '\t', '\t': '\t', 'n': '\n', 'v': '\v', 'f': '\f', 'r': '\r', 'e': 0x1b,
+	' ': ' ', '"': '"', '/': '/', '\\': '\\', 'N': 0x85, '_': 0xa0, 'L': 0x2028, 'P': 0x2029,
+}
+
+// resolve converts a plain scalar to a value using the YAML 1.2 core schema.
+func resolve(s string) (interface{}, error) {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	switch {
+	case yamlOct.MatchString(s), yamlHex.MatchString(s):
+		base := 8
+		if s[1] == 'x' {
+			base = 16
+		}
+		u, err := strconv.ParseUint(s[2:], base, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", s)
+		}
+		return float64(u), nil
+	case yamlInt.MatchString(s), yamlFloat.MatchString(s):
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", s)
+		}
+		return f, nil
+	case yamlInf.MatchString(s):
+		return nil, fmt.Errorf("%s cannot be represented in JSON", s)
+	}
+	return s, nil
+}
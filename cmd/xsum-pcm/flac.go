@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// See https://xiph.org/flac/format.html
+
+type flacStreamInfo struct {
+	channels      int
+	bitsPerSample int
+	totalSamples  uint64
+	md5           [16]byte
+}
+
+// decodeFLAC writes the interleaved, little-endian PCM samples of a FLAC stream to w.
+// Like the STREAMINFO MD5 checksum, each sample is written using the fewest whole bytes (e.g., 2 bytes for 12-bit samples).
+// The MD5 checksum of the samples is verified against STREAMINFO, unless it is unset.
+// If STREAMINFO does not contain an MD5 checksum, missingMD5 is true.
+func decodeFLAC(r io.Reader, w io.Writer) (missingMD5 bool, err error) {
+	br := bufio.NewReader(r)
+	info, err := readFLACMetadata(br)
+	if err != nil {
+		return false, err
+	}
+	if info.bitsPerSample < 4 {
+		return false, errors.New("invalid flac sample size")
+	}
+	sum := md5.New()
+	out := bufio.NewWriter(io.MultiWriter(w, sum))
+	d := &flacDecoder{bits: bitReader{r: br}, info: info}
+	var total uint64
+	buf := make([]byte, 4)
+	width := (info.bitsPerSample + 7) / 8
+	for {
+		samples, err := d.frame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return false, err
+		}
+		for i := range samples[0] {
+			for _, ch := range samples {
+				binary.LittleEndian.PutUint32(buf, uint32(ch[i]))
+				if _, err := out.Write(buf[:width]); err != nil {
+					return false, err
+				}
+			}
+		}
+		total += uint64(len(samples[0]))
+	}
+	if err := out.Flush(); err != nil {
+		return false, err
+	}
+	if info.totalSamples != 0 && total != info.totalSamples {
+		return false, fmt.Errorf("truncated flac (%d of %d samples)", total, info.totalSamples)
+	}
+	if info.md5 == ([16]byte{}) {
+		return true, nil
+	}
+	if real := sum.Sum(nil); !bytes.Equal(real, info.md5[:]) {
+		return false, fmt.Errorf("corrupted flac (%x != %x)", info.md5, real)
+	}
+	return false, nil
+}
+
+func readFLACMetadata(r *bufio.Reader) (*flacStreamInfo, error) {
+	if err := skipID3v2(r); err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != "fLaC" {
+		return nil, errors.New("invalid flac stream")
+	}
+	var info *flacStreamInfo
+	for last := false; !last; {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		last = header[0]&0x80 != 0
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		if header[0]&0x7f != 0 { // not STREAMINFO
+			if _, err := io.CopyN(io.Discard, r, size); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if size != 34 {
+			return nil, errors.New("invalid flac STREAMINFO")
+		}
+		block := make([]byte, size)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return nil, err
+		}
+		packed := binary.BigEndian.Uint64(block[10:18]) // rate(20), channels-1(3), bps-1(5), samples(36)
+		info = &flacStreamInfo{
+			channels:      int(packed>>41&0x7) + 1,
+			bitsPerSample: int(packed>>36&0x1f) + 1,
+			totalSamples:  packed & (1<<36 - 1),
+		}
+		copy(info.md5[:], block[18:])
+	}
+	if info == nil {
+		return nil, errors.New("missing flac STREAMINFO")
+	}
+	return info, nil
+}
+
+// skipID3v2 skips an ID3v2 tag, if present
+func skipID3v2(r *bufio.Reader) error {
+	header, err := r.Peek(10)
+	if err != nil || string(header[:3]) != "ID3" {
+		return nil
+	}
+	size := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+	if header[5]&0x10 != 0 { // footer
+		size += 10
+	}
+	_, err = io.CopyN(io.Discard, r, 10+size)
+	return err
+}
+
+type flacDecoder struct {
+	bits bitReader
+	info *flacStreamInfo
+}
+
+// frame returns the decoded samples of the next frame for each channel.
+// At the end of the stream, io.EOF is returned.
+// Frame CRCs are not verified, because the STREAMINFO MD5 checksum covers all samples.
+func (d *flacDecoder) frame() ([][]int64, error) {
+	if _, err := d.bits.r.Peek(1); err == io.EOF {
+		return nil, io.EOF
+	}
+	if tag, err := d.bits.r.Peek(3); err == nil && string(tag) == "TAG" {
+		return nil, io.EOF // ID3v1 tag
+	}
+	br := &d.bits
+	sync, err := br.read(14)
+	if err != nil {
+		return nil, err
+	}
+	if sync != 0x3ffe {
+		return nil, errors.New("invalid flac frame sync code")
+	}
+	if _, err := br.read(2); err != nil { // reserved, blocking strategy
+		return nil, err
+	}
+	header, err := br.read(16)
+	if err != nil {
+		return nil, err
+	}
+	blockCode, rateCode := header>>12, header>>8&0xf
+	assign, sizeCode := header>>4&0xf, header>>1&0x7
+	if err := br.skipUTF8(); err != nil {
+		return nil, err
+	}
+
+	var blockSize int
+	switch {
+	case blockCode == 1:
+		blockSize = 192
+	case blockCode >= 2 && blockCode <= 5:
+		blockSize = 576 << (blockCode - 2)
+	case blockCode == 6, blockCode == 7:
+		n, err := br.read(uint(blockCode-5) * 8)
+		if err != nil {
+			return nil, err
+		}
+		blockSize = int(n) + 1
+	case blockCode >= 8:
+		blockSize = 256 << (blockCode - 8)
+	default:
+		return nil, errors.New("invalid flac block size")
+	}
+	switch rateCode {
+	case 12:
+		_, err = br.read(8)
+	case 13, 14:
+		_, err = br.read(16)
+	case 15:
+		err = errors.New("invalid flac sample rate")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var bps int
+	switch sizeCode {
+	case 0:
+		bps = d.info.bitsPerSample
+	case 1, 2:
+		bps = 4 + 4*int(sizeCode)
+	case 4, 5, 6:
+		bps = 4 * int(sizeCode)
+	case 7:
+		bps = 32
+	default:
+		return nil, errors.New("invalid flac sample size")
+	}
+	if bps != d.info.bitsPerSample {
+		return nil, errors.New("flac sample size changed within stream")
+	}
+	channels := int(assign) + 1
+	if assign >= 8 && assign <= 10 {
+		channels = 2
+	} else if assign > 10 {
+		return nil, errors.New("invalid flac channel assignment")
+	}
+	if channels != d.info.channels {
+		return nil, errors.New("flac channel count changed within stream")
+	}
+	if _, err := br.read(8); err != nil { // CRC-8
+		return nil, err
+	}
+
+	samples := make([][]int64, channels)
+	for ch := range samples {
+		sbps := bps
+		if (assign == 8 || assign == 10) && ch == 1 || assign == 9 && ch == 0 {
+			sbps++ // side channel
+		}
+		samples[ch], err = d.subframe(blockSize, sbps)
+		if err != nil {
+			return nil, err
+		}
+	}
+	br.align()
+	if _, err := br.read(16); err != nil { // CRC-16
+		return nil, err
+	}
+
+	switch assign {
+	case 8: // left/side
+		for i, side := range samples[1] {
+			samples[1][i] = samples[0][i] - side
+		}
+	case 9: // side/right
+		for i, side := range samples[0] {
+			samples[0][i] = side + samples[1][i]
+		}
+	case 10: // mid/side
+		for i, side := range samples[1] {
+			mid := samples[0][i]<<1 | side&1
+			samples[0][i], samples[1][i] = (mid+side)>>1, (mid-side)>>1
+		}
+	}
+	return samples, nil
+}
+
+func (d *flacDecoder) subframe(blockSize, bps int) ([]int64, error) {
+	br := &d.bits
+	header, err := br.read(8)
+	if err != nil {
+		return nil, err
+	}
+	if header&0x80 != 0 {
+		return nil, errors.New("invalid flac subframe")
+	}
+	kind := header >> 1 & 0x3f
+	wasted := 0
+	if header&1 != 0 {
+		n, err := br.readUnary()
+		if err != nil {
+			return nil, err
+		}
+		wasted = int(n) + 1
+		bps -= wasted
+	}
+	if bps <= 0 {
+		return nil, errors.New("invalid flac wasted bits")
+	}
+
+	out := make([]int64, blockSize)
+	switch {
+	case kind == 0: // constant
+		v, err := br.readSigned(uint(bps))
+		if err != nil {
+			return nil, err
+		}
+		for i := range out {
+			out[i] = v
+		}
+	case kind == 1: // verbatim
+		for i := range out {
+			if out[i], err = br.readSigned(uint(bps)); err != nil {
+				return nil, err
+			}
+		}
+	case kind >= 8 && kind <= 12: // fixed
+		order := int(kind & 7)
+		if err := d.warmup(out, order, bps); err != nil {
+			return nil, err
+		}
+		if err := d.residual(out, order); err != nil {
+			return nil, err
+		}
+		fixedPredict(out, order)
+	case kind >= 32: // LPC
+		order := int(kind&0x1f) + 1
+		if err := d.warmup(out, order, bps); err != nil {
+			return nil, err
+		}
+		precision, err := br.read(4)
+		if err != nil {
+			return nil, err
+		}
+		if precision == 15 {
+			return nil, errors.New("invalid flac LPC precision")
+		}
+		shift, err := br.readSigned(5)
+		if err != nil {
+			return nil, err
+		}
+		if shift < 0 {
+			return nil, errors.New("invalid flac LPC shift")
+		}
+		coeffs := make([]int64, order)
+		for i := range coeffs {
+			if coeffs[i], err = br.readSigned(uint(precision) + 1); err != nil {
+				return nil, err
+			}
+		}
+		if err := d.residual(out, order); err != nil {
+			return nil, err
+		}
+		for i := order; i < len(out); i++ {
+			var sum int64
+			for j, c := range coeffs {
+				sum += c * out[i-j-1]
+			}
+			out[i] += sum >> uint(shift)
+		}
+	default:
+		return nil, errors.New("invalid flac subframe type")
+	}
+	if wasted > 0 {
+		for i := range out {
+			out[i] <<= uint(wasted)
+		}
+	}
+	return out, nil
+}
+
+func (d *flacDecoder) warmup(out []int64, order, bps int) error {
+	if order > len(out) {
+		return errors.New("invalid flac predictor order")
+	}
+	for i := 0; i < order; i++ {
+		var err error
+		if out[i], err = d.bits.readSigned(uint(bps)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// residual reads the Rice-coded residual into out, after the warm-up samples
+func (d *flacDecoder) residual(out []int64, order int) error {
+	br := &d.bits
+	method, err := br.read(2)
+	if err != nil {
+		return err
+	}
+	paramBits, escape := uint(4), uint64(15)
+	switch method {
+	case 0:
+	case 1:
+		paramBits, escape = 5, 31
+	default:
+		return errors.New("invalid flac residual coding method")
+	}
+	partOrder, err := br.read(4)
+	if err != nil {
+		return err
+	}
+	parts := 1 << partOrder
+	if len(out)%parts != 0 || len(out)/parts < order {
+		return errors.New("invalid flac partition order")
+	}
+	i := order
+	for p := 0; p < parts; p++ {
+		end := (p + 1) * len(out) / parts
+		param, err := br.read(paramBits)
+		if err != nil {
+			return err
+		}
+		if param == escape {
+			n, err := br.read(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				if n == 0 {
+					out[i] = 0
+				} else if out[i], err = br.readSigned(uint(n)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			q, err := br.readUnary()
+			if err != nil {
+				return err
+			}
+			r, err := br.read(uint(param))
+			if err != nil {
+				return err
+			}
+			v := q<<param | r
+			out[i] = int64(v>>1) ^ -int64(v&1)
+		}
+	}
+	return nil
+}
+
+func fixedPredict(out []int64, order int) {
+	for i := order; i < len(out); i++ {
+		switch order {
+		case 1:
+			out[i] += out[i-1]
+		case 2:
+			out[i] += 2*out[i-1] - out[i-2]
+		case 3:
+			out[i] += 3*out[i-1] - 3*out[i-2] + out[i-3]
+		case 4:
+			out[i] += 4*out[i-1] - 6*out[i-2] + 4*out[i-3] - out[i-4]
+		}
+	}
+}
+
+// bitReader reads big-endian bit fields
+type bitReader struct {
+	r   *bufio.Reader
+	buf uint64
+	n   uint // unread bits in buf
+}
+
+func (b *bitReader) fill() error {
+	c, err := b.r.ReadByte()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+	b.buf = b.buf<<8 | uint64(c)
+	b.n += 8
+	return nil
+}
+
+// read returns the next n bits, where n <= 56
+func (b *bitReader) read(n uint) (uint64, error) {
+	for b.n < n {
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+	b.n -= n
+	return b.buf >> b.n & (1<<n - 1), nil
+}
+
+func (b *bitReader) readSigned(n uint) (int64, error) {
+	v, err := b.read(n)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v<<(64-n)) >> (64 - n), nil
+}
+
+// readUnary returns the number of zero bits before the next one bit
+func (b *bitReader) readUnary() (uint64, error) {
+	var v uint64
+	for {
+		if b.n == 0 {
+			if err := b.fill(); err != nil {
+				return 0, err
+			}
+		}
+		rem := b.buf & (1<<b.n - 1)
+		if rem == 0 {
+			v += uint64(b.n)
+			b.n = 0
+			continue
+		}
+		zeros := uint(bits.LeadingZeros64(rem)) - (64 - b.n)
+		b.n -= zeros + 1
+		return v + uint64(zeros), nil
+	}
+}
+
+// skipUTF8 skips a UTF-8-like coded frame or sample number
+func (b *bitReader) skipUTF8() error {
+	c, err := b.read(8)
+	if err != nil {
+		return err
+	}
+	n := bits.LeadingZeros8(^uint8(c))
+	if n == 1 || n > 7 {
+		return errors.New("invalid flac frame number")
+	}
+	for i := 1; i < n; i++ {
+		if _, err := b.read(8); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// align discards bits until the next byte boundary
+func (b *bitReader) align() {
+	b.n -= b.n % 8
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,15 +11,28 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/sclevine/xsum"
 	"github.com/sclevine/xsum/cli"
 )
 
 var Version = "0.0.0"
 
+var errUnsupported = errors.New("unsupported audio format")
+
 func main() {
 	alg := "sha256"
-	if strings.HasPrefix(os.Args[0], "xsum-pcm-") {
-		alg = strings.TrimPrefix(os.Args[0], "xsum-pcm-")
+	if name := filepath.Base(os.Args[0]); strings.HasPrefix(name, "xsum-pcm-") {
+		alg = strings.TrimPrefix(strings.TrimSuffix(name, filepath.Ext(name)), "xsum-pcm-")
+	}
+	if len(os.Args) == 2 && os.Args[1] == xsum.PluginInfoArg {
+		hash, err := cli.ParseHash(alg)
+		if err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+		if err := cli.WritePluginInfo(os.Stdout, os.Args[0], Version, hash); err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+		return
 	}
 
 	switch os.Getenv("XSUM_PLUGIN_TYPE") {
@@ -38,12 +53,14 @@ func main() {
 		}
 		fmt.Printf("%x", out)
 	default: // "data"
-		if len(os.Args) < 2 {
-			log.Fatal("Error: xsum PCM plugin does not support audio input via stdin")
-		} else if len(os.Args) > 2 {
-			log.Fatalf("Error: extra arguments: %s", strings.Join(os.Args[2:], ", "))
+		path, temp, err := inputPath()
+		if err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+		out, err := pcmSHA(path, alg)
+		if temp {
+			os.Remove(path)
 		}
-		out, err := pcmSHA(os.Args[1], alg)
 		if err != nil {
 			log.Fatalf("Error: %s", err)
 		}
@@ -51,6 +68,42 @@ func main() {
 	}
 }
 
+// inputPath returns the path to the input file, and whether the file is temporary.
+// Audio provided via stdin is buffered to a temporary file, because ffmpeg may need to seek.
+func inputPath() (path string, temp bool, err error) {
+	switch len(os.Args) {
+	case 0, 1:
+		path, err = bufferFile(os.Stdin)
+		return path, true, err
+	case 2:
+		return os.Args[1], false, nil
+	default:
+		return "", false, fmt.Errorf("extra arguments: %s", strings.Join(os.Args[2:], ", "))
+	}
+}
+
+func bufferFile(r io.Reader) (string, error) {
+	f, err := os.CreateTemp("", "xsum-pcm-")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// warn writes a warning in the form specified by the plugin interface.
+func warn(category, format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "warning: %s: %s\n", category, fmt.Sprintf(format, a...))
+}
+
 func input() (io.ReadCloser, error) {
 	switch len(os.Args) {
 	case 0, 1:
@@ -67,71 +120,105 @@ func input() (io.ReadCloser, error) {
 }
 
 func pcmSHA(path, alg string) (string, error) {
-	ext := strings.ToLower(filepath.Ext(path))
-	cmd := exec.Command("ffprobe",
-		"-v", "error",
-		"-select_streams", "a:0",
-		"-show_entries", "stream=bits_per_raw_sample",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		path,
-	)
-	out, err := cmd.Output()
+	if sum, err := pcmNative(path, alg); err != errUnsupported {
+		return sum, err
+	}
+	bits, err := probe(path, "bits_per_raw_sample")
 	if err != nil {
-		if ee, ok := err.(*exec.ExitError); ok {
-			log.Printf("%s\n", ee.Stderr)
-		}
 		return "", err
 	}
-	bits := strings.TrimSpace(string(out))
-
 	if bits == "N/A" {
-		cmd := exec.Command("ffprobe",
-			"-v", "error",
-			"-select_streams", "a:0",
-			"-show_entries", "stream=sample_fmt",
-			"-of", "default=noprint_wrappers=1:nokey=1",
-			path,
-		)
-		out, err := cmd.Output()
+		format, err := probe(path, "sample_fmt")
 		if err != nil {
-			if ee, ok := err.(*exec.ExitError); ok {
-				log.Printf("%s\n", ee.Stderr)
-			}
 			return "", err
 		}
-		switch strings.TrimSpace(string(out)) {
+		switch format {
 		case "s16", "s16p":
 			bits = "16"
 		default:
-			switch ext {
-			case ".m4a":
-				log.Printf("Warning: assuming '%s' is lossy m4a", path)
-				fallthrough
-			case ".mp3", ".ogg", ".opus":
+			codec, err := probe(path, "codec_name")
+			if err != nil {
+				return "", err
+			}
+			switch codec {
+			case "aac", "mp3", "vorbis", "opus":
+				warn("lossy", "assuming '%s' is lossy %s", path, codec)
 				return pcmSHAOpt(path, "16", alg)
 			default:
 				return "", fmt.Errorf("invalid bit depth for '%s'", path)
 			}
 		}
 	}
+	return pcmSHAOpt(path, bits, alg)
+}
 
-	if ext == ".flac" {
-		real, err := pcmSHAOpt(path, bits, "md5")
-		if err != nil {
-			return "", err
-		}
-		claim, err := flacMD5(path)
-		if err != nil {
-			return "", err
-		}
-		if claim == "00000000000000000000000000000000" {
-			log.Printf("Warning: flac '%s' missing PCM md5 checksum", path)
-		} else if real != claim {
-			return "", fmt.Errorf("corrupted flac '%s' (%s != %s)", path, claim, real)
+// probe returns an entry describing the first audio stream in path.
+func probe(path, entry string) (string, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream="+entry,
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			log.Printf("%s\n", ee.Stderr)
 		}
+		return "", err
 	}
+	return strings.TrimSpace(string(out)), nil
+}
 
-	return pcmSHAOpt(path, bits, alg)
+// pcmNative calculates the checksum of the PCM data in lossless WAV, AIFF, and FLAC files without ffmpeg.
+// For all other files, errUnsupported is returned.
+func pcmNative(path, alg string) (string, error) {
+	hash, err := cli.ParseHash(alg)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if err := skipID3v2(r); err != nil {
+		return "", err
+	}
+	magic, _ := r.Peek(12)
+	var decode func(io.Reader, io.Writer) error
+	switch {
+	case len(magic) < 12:
+		return "", errUnsupported
+	case string(magic[:4]) == "fLaC":
+		decode = func(r io.Reader, w io.Writer) error {
+			missingMD5, err := decodeFLAC(r, w)
+			if missingMD5 {
+				warn("unverified", "flac '%s' missing PCM md5 checksum", path)
+			}
+			return err
+		}
+	case string(magic[:4]) == "RIFF" && string(magic[8:]) == "WAVE":
+		decode = decodeWAV
+	case string(magic[:4]) == "FORM" && (string(magic[8:]) == "AIFF" || string(magic[8:]) == "AIFC"):
+		decode = decodeAIFF
+	default:
+		return "", errUnsupported
+	}
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(decode(r, pw))
+	}()
+	sum, err := hash.Data(pr)
+	if err == errUnsupported {
+		return "", err
+	} else if err != nil {
+		return "", fmt.Errorf("invalid audio '%s': %w", path, err)
+	}
+	return fmt.Sprintf("%x", sum), nil
 }
 
 func pcmSHAOpt(path, bits, hash string) (string, error) {
@@ -161,18 +248,3 @@ func pcmSHAOpt(path, bits, hash string) (string, error) {
 	}
 	return string(out[len(hashU)+1 : len(out)-1]), nil
 }
-
-func flacMD5(path string) (string, error) {
-	cmd := exec.Command("metaflac", "--show-md5sum", path)
-	out, err := cmd.Output()
-	if err != nil {
-		if ee, ok := err.(*exec.ExitError); ok {
-			log.Printf("%s\n", ee.Stderr)
-		}
-		return "", err
-	}
-	if len(out) != 33 {
-		return "", fmt.Errorf("invalid checksum '%s'", strings.TrimSpace(string(out)))
-	}
-	return string(out[:32]), nil
-}
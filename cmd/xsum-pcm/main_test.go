@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testFrames = 3000
+
+// testSample matches the samples encoded in testdata/test.flac
+func testSample(i, ch int) int16 {
+	return int16(i*(13+ch*16)%4000 - 2000)
+}
+
+func testPCM(order binary.ByteOrder) []byte {
+	out := make([]byte, testFrames*4)
+	for i := 0; i < testFrames; i++ {
+		order.PutUint16(out[i*4:], uint16(testSample(i, 0)))
+		order.PutUint16(out[i*4+2:], uint16(testSample(i, 1)))
+	}
+	return out
+}
+
+func TestPCMNative(t *testing.T) {
+	dir := t.TempDir()
+	pcm := testPCM(binary.LittleEndian)
+	expected := fmt.Sprintf("%x", sha256.Sum256(pcm))
+
+	wav := &bytes.Buffer{}
+	wav.WriteString("RIFF")
+	binary.Write(wav, binary.LittleEndian, uint32(36+len(pcm)))
+	wav.WriteString("WAVEfmt ")
+	binary.Write(wav, binary.LittleEndian, []uint32{16, 2<<16 | 1, 8000, 8000 * 4, 16<<16 | 4})
+	wav.WriteString("data")
+	binary.Write(wav, binary.LittleEndian, uint32(len(pcm)))
+	wav.Write(pcm)
+
+	pcmBE := testPCM(binary.BigEndian)
+	aiff := &bytes.Buffer{}
+	aiff.WriteString("FORM")
+	binary.Write(aiff, binary.BigEndian, uint32(4+26+16+len(pcmBE)))
+	aiff.WriteString("AIFFCOMM")
+	binary.Write(aiff, binary.BigEndian, []uint32{18, 2<<16 | testFrames>>16, testFrames<<16 | 16})
+	aiff.Write([]byte{0x40, 0x0b, 0xfa, 0, 0, 0, 0, 0, 0, 0}) // 8000 Hz
+	aiff.WriteString("SSND")
+	binary.Write(aiff, binary.BigEndian, []uint32{uint32(8 + len(pcmBE)), 0, 0})
+	aiff.Write(pcmBE)
+
+	for name, data := range map[string][]byte{"test.wav": wav.Bytes(), "test.aiff": aiff.Bytes()} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{
+		filepath.Join("testdata", "test.flac"),
+		filepath.Join(dir, "test.wav"),
+		filepath.Join(dir, "test.aiff"),
+	} {
+		sum, err := pcmNative(path, "sha256")
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if sum != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, sum)
+		}
+	}
+
+	flac, err := os.ReadFile(filepath.Join("testdata", "test.flac"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flac[len(flac)/2] ^= 0x10
+	corrupt := filepath.Join(dir, "corrupt.flac")
+	if err := os.WriteFile(corrupt, flac, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pcmNative(corrupt, "sha256"); err == nil || err == errUnsupported {
+		t.Errorf("Expected error for corrupted flac, got: %v", err)
+	}
+
+	other := filepath.Join(dir, "test.mp3")
+	if err := os.WriteFile(other, []byte("not lossless audio data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pcmNative(other, "sha256"); err != errUnsupported {
+		t.Errorf("Expected unsupported format, got: %v", err)
+	}
+}
+
+func TestDecodeFLAC_bitDepths(t *testing.T) {
+	for _, bps := range []int{8, 12, 20} {
+		width := (bps + 7) / 8
+		var pcm []byte
+		for i := 0; i < 64; i++ {
+			for ch := 0; ch < 2; ch++ {
+				sample := int64(i*(3+ch*5))%(1<<(bps-1)) - int64(ch)<<(bps-2)
+				for j := 0; j < width; j++ {
+					pcm = append(pcm, byte(sample>>(8*j)))
+				}
+			}
+		}
+		for _, corrupt := range []bool{false, true} {
+			sum := md5.Sum(pcm)
+			if corrupt {
+				sum[0] ^= 1
+			}
+			out := &bytes.Buffer{}
+			_, err := decodeFLAC(bytes.NewReader(testFLAC(bps, 64, pcm, sum)), out)
+			if corrupt {
+				if err == nil || err == errUnsupported {
+					t.Errorf("%d-bit: expected MD5 mismatch, got: %v", bps, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%d-bit: %s", bps, err)
+			} else if !bytes.Equal(out.Bytes(), pcm) {
+				t.Errorf("%d-bit: unexpected samples %x", bps, out.Bytes())
+			}
+		}
+	}
+}
+
+// testFLAC encodes stereo samples (in STREAMINFO MD5 layout) as a single frame with VERBATIM subframes.
+// Frame CRCs are zero, because they are not verified.
+func testFLAC(bps, frames int, pcm []byte, sum [16]byte) []byte {
+	var bits []bool
+	put := func(v uint64, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, v>>uint(i)&1 == 1)
+		}
+	}
+	put(16, 16)             // min block size
+	put(uint64(frames), 16) // max block size
+	put(0, 48)              // min/max frame size
+	put(8000, 20)           // sample rate
+	put(1, 3)               // channels-1
+	put(uint64(bps-1), 5)   // bits per sample-1
+	put(uint64(frames), 36) // total samples
+	for _, b := range sum {
+		put(uint64(b), 8)
+	}
+	sizeCode := map[int]uint64{8: 1, 12: 2, 20: 5}[bps]
+	put(0x3ffe<<2, 16)       // sync, reserved, fixed blocking
+	put(6<<4, 8)             // 8-bit block size, sample rate from STREAMINFO
+	put(1<<4|sizeCode<<1, 8) // independent stereo, sample size
+	put(0, 8)                // frame number
+	put(uint64(frames-1), 8) // block size-1
+	put(0, 8)                // CRC-8
+	width := (bps + 7) / 8
+	for ch := 0; ch < 2; ch++ {
+		put(1<<1, 8) // VERBATIM, no wasted bits
+		for i := 0; i < frames; i++ {
+			var sample uint64
+			for j := width - 1; j >= 0; j-- {
+				sample = sample<<8 | uint64(pcm[(i*2+ch)*width+j])
+			}
+			put(sample, bps)
+		}
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	put(0, 16) // CRC-16
+
+	out := []byte("fLaC\x80\x00\x00\x22")
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for _, bit := range bits[i : i+8] {
+			b <<= 1
+			if bit {
+				b |= 1
+			}
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+func TestBufferFile(t *testing.T) {
+	flac, err := os.ReadFile(filepath.Join("testdata", "test.flac"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := bufferFile(bytes.NewReader(flac))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	sum, err := pcmNative(path, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := fmt.Sprintf("%x", sha256.Sum256(testPCM(binary.LittleEndian))); sum != expected {
+		t.Errorf("Expected %s, got %s", expected, sum)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// decodeWAV writes the little-endian PCM samples of a WAV stream to w.
+// Only 16, 24, and 32-bit integer samples are supported.
+func decodeWAV(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WAVE" {
+		return errUnsupported
+	}
+	blockAlign := 0
+	for {
+		id, size, err := readChunkHeader(br, binary.LittleEndian)
+		if err != nil {
+			return err
+		}
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return errors.New("invalid wav fmt chunk")
+			}
+			chunk := make([]byte, size+size%2)
+			if _, err := io.ReadFull(br, chunk); err != nil {
+				return err
+			}
+			format := binary.LittleEndian.Uint16(chunk[0:2])
+			channels := int(binary.LittleEndian.Uint16(chunk[2:4]))
+			blockAlign = int(binary.LittleEndian.Uint16(chunk[12:14]))
+			bits := int(binary.LittleEndian.Uint16(chunk[14:16]))
+			if format == 0xfffe && size >= 40 { // WAVE_FORMAT_EXTENSIBLE
+				if int(binary.LittleEndian.Uint16(chunk[18:20])) != bits {
+					return errUnsupported
+				}
+				format = binary.LittleEndian.Uint16(chunk[24:26])
+			}
+			if format != 1 || !supportedBits(bits) || blockAlign != channels*bits/8 || channels == 0 {
+				return errUnsupported
+			}
+		case "data":
+			if blockAlign == 0 {
+				return errUnsupported
+			}
+			_, err := io.Copy(w, io.LimitReader(br, size-size%int64(blockAlign)))
+			return err
+		default:
+			if _, err := io.CopyN(io.Discard, br, size+size%2); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// See http://www-mmsp.ece.mcgill.ca/Documents/AudioFormats/AIFF/Docs/AIFF-1.3.pdf
+
+// decodeAIFF writes the little-endian PCM samples of an AIFF or AIFF-C stream to w.
+// Only uncompressed 16, 24, and 32-bit integer samples are supported.
+func decodeAIFF(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return err
+	}
+	if string(header[:4]) != "FORM" || (string(header[8:]) != "AIFF" && string(header[8:]) != "AIFC") {
+		return errUnsupported
+	}
+	var (
+		width  int
+		frames int64
+		swap   = true
+	)
+	for {
+		id, size, err := readChunkHeader(br, binary.BigEndian)
+		if err != nil {
+			return err
+		}
+		switch id {
+		case "COMM":
+			if size < 18 {
+				return errors.New("invalid aiff COMM chunk")
+			}
+			chunk := make([]byte, size+size%2)
+			if _, err := io.ReadFull(br, chunk); err != nil {
+				return err
+			}
+			channels := int(binary.BigEndian.Uint16(chunk[0:2]))
+			bits := int(binary.BigEndian.Uint16(chunk[6:8]))
+			if string(header[8:]) == "AIFC" {
+				if size < 22 {
+					return errors.New("invalid aiff COMM chunk")
+				}
+				switch string(chunk[18:22]) {
+				case "NONE", "twos":
+				case "sowt":
+					swap = false
+				default:
+					return errUnsupported
+				}
+			}
+			if !supportedBits(bits) || channels == 0 {
+				return errUnsupported
+			}
+			width = bits / 8
+			frames = int64(binary.BigEndian.Uint32(chunk[2:6])) * int64(channels)
+		case "SSND":
+			if width == 0 {
+				return errUnsupported
+			}
+			offset := make([]byte, 8)
+			if _, err := io.ReadFull(br, offset); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(io.Discard, br, int64(binary.BigEndian.Uint32(offset[:4]))); err != nil {
+				return err
+			}
+			data := io.LimitReader(br, frames*int64(width))
+			if !swap {
+				_, err := io.Copy(w, data)
+				return err
+			}
+			return copySwapped(w, data, width)
+		default:
+			if _, err := io.CopyN(io.Discard, br, size+size%2); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func supportedBits(bits int) bool {
+	return bits == 16 || bits == 24 || bits == 32
+}
+
+func readChunkHeader(r io.Reader, order binary.ByteOrder) (id string, size int64, err error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err == io.EOF {
+		return "", 0, errors.New("missing audio data")
+	} else if err != nil {
+		return "", 0, err
+	}
+	return string(header[:4]), int64(order.Uint32(header[4:])), nil
+}
+
+// copySwapped copies samples of the given width from r to w, reversing the byte order of each sample
+func copySwapped(w io.Writer, r io.Reader, width int) error {
+	buf := make([]byte, 4096*width)
+	for {
+		n, err := io.ReadFull(r, buf)
+		n -= n % width
+		for i := 0; i < n; i += width {
+			for j, k := i, i+width-1; j < k; j, k = j+1, k-1 {
+				buf[j], buf[k] = buf[k], buf[j]
+			}
+		}
+		if _, werr := w.Write(buf[:n]); werr != nil {
+			return werr
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/cli"
+)
+
+var Version = "0.0.0"
+
+var bom = []byte{0xef, 0xbb, 0xbf}
+
+func main() {
+	trim, alg := parseName(os.Args[0])
+	hash, err := cli.ParseHash(alg)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	if len(os.Args) == 2 && os.Args[1] == xsum.PluginInfoArg {
+		if err := cli.WritePluginInfo(os.Stdout, os.Args[0], Version, hash); err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+		return
+	}
+	r, err := input()
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	defer r.Close()
+
+	var out []byte
+	switch os.Getenv("XSUM_PLUGIN_TYPE") {
+	case "metadata":
+		out, err = hash.Data(r)
+	default: // "data"
+		out, err = textSum(r, hash, trim)
+	}
+	if err != nil {
+		r.Close()
+		log.Fatalf("Error: %s", err)
+	}
+	fmt.Printf("%x", out)
+}
+
+// parseName determines the plugin variant from its file name.
+// The name xsum-text[-trim][-<alg>] selects trailing whitespace removal and the inner algorithm.
+func parseName(arg0 string) (trim bool, alg string) {
+	name := filepath.Base(arg0)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = strings.TrimPrefix(name, "xsum-text")
+	if strings.HasPrefix(name, "-trim") {
+		trim = true
+		name = strings.TrimPrefix(name, "-trim")
+	}
+	if strings.HasPrefix(name, "-") {
+		return trim, name[1:]
+	}
+	return trim, "sha256"
+}
+
+func input() (io.ReadCloser, error) {
+	switch len(os.Args) {
+	case 0, 1:
+		return io.NopCloser(os.Stdin), nil
+	case 2:
+		f, err := os.Open(os.Args[1])
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("extra arguments: %s", strings.Join(os.Args[2:], ", "))
+	}
+}
+
+// textSum hashes normalized text.
+func textSum(r io.Reader, hash xsum.Hash, trim bool) ([]byte, error) {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(normalize(pw, r, trim))
+	}()
+	return hash.Data(pr)
+}
+
+// normalize copies text from r to w, removing a leading UTF-8 BOM and converting CRLF and CR line endings to LF.
+// If trim is true, spaces and tabs at the end of each line are also removed.
+func normalize(w io.Writer, r io.Reader, trim bool) error {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(len(bom)); err == nil && bytes.Equal(b, bom) {
+		br.Discard(len(bom))
+	}
+	bw := bufio.NewWriter(w)
+	var (
+		ws []byte // pending whitespace
+		cr bool
+	)
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if cr && c == '\n' {
+			cr = false
+			continue
+		}
+		cr = c == '\r'
+		switch {
+		case c == '\r' || c == '\n':
+			ws = ws[:0]
+			bw.WriteByte('\n')
+		case trim && (c == ' ' || c == '\t'):
+			ws = append(ws, c)
+		default:
+			bw.Write(ws)
+			ws = ws[:0]
+			bw.WriteByte(c)
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, tt := range []struct {
+		in, out, trimOut string
+	}{
+		{"a\nb\n", "a\nb\n", "a\nb\n"},
+		{"a\r\nb\r\n", "a\nb\n", "a\nb\n"},
+		{"a\rb\r", "a\nb\n", "a\nb\n"},
+		{"a\r\r\nb", "a\n\nb", "a\n\nb"},
+		{"\xef\xbb\xbfa\r\n", "a\n", "a\n"},
+		{"a\xef\xbb\xbf", "a\xef\xbb\xbf", "a\xef\xbb\xbf"},
+		{"a \t\r\n b  c \n\t", "a \t\n b  c \n\t", "a\n b  c\n"},
+		{"", "", ""},
+	} {
+		for _, trim := range []bool{false, true} {
+			expected := tt.out
+			if trim {
+				expected = tt.trimOut
+			}
+			out := &bytes.Buffer{}
+			if err := normalize(out, strings.NewReader(tt.in), trim); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != expected {
+				t.Errorf("normalize(%q, %t): expected %q, got %q", tt.in, trim, expected, out.String())
+			}
+		}
+	}
+}
+
+func TestParseName(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		trim bool
+		alg  string
+	}{
+		{"xsum-text", false, "sha256"},
+		{"/usr/local/bin/xsum-text-md5", false, "md5"},
+		{"xsum-text-trim", true, "sha256"},
+		{"xsum-text-trim-sha512-256", true, "sha512-256"},
+		{"bin/xsum-text-trim-blake3.exe", true, "blake3"},
+	} {
+		trim, alg := parseName(tt.name)
+		if trim != tt.trim || alg != tt.alg {
+			t.Errorf("parseName(%q): expected %t, %s, got %t, %s", tt.name, tt.trim, tt.alg, trim, alg)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/cli"
+)
+
+// See https://datatracker.ietf.org/doc/html/rfc8493
+
+type BagOptions struct {
+	Create   BagCreateOptions   `command:"create" description:"Move directory contents into data/ and write BagIt manifests and tag files"`
+	Validate BagValidateOptions `command:"validate" description:"Validate completeness and fixity of a bag"`
+}
+
+type BagCreateOptions struct {
+	Algorithm string  `short:"a" long:"algorithm" default:"sha256" description:"Use specified hash functions (comma-separated)"`
+	Args      DirArgs `positional-args:"yes" required:"yes"`
+}
+
+type BagValidateOptions struct {
+	Quiet bool    `short:"q" long:"quiet" description:"Suppress passing checksums"`
+	Args  DirArgs `positional-args:"yes" required:"yes"`
+}
+
+const (
+	bagDeclaration = "bagit.txt"
+	bagInfo        = "bag-info.txt"
+	bagPayload     = "data"
+)
+
+func mainBag(args []string) {
+	var opts BagOptions
+	parser := parseCommand("xsum bag", &opts, args)
+	var err error
+	switch parser.Active.Name {
+	case "create":
+		err = CreateBag(&opts.Create)
+	case "validate":
+		err = ValidateBag(&opts.Validate)
+	}
+	if err != nil {
+		log.Fatalf("xsum: %s", err)
+	}
+}
+
+// CreateBag converts a directory into a bag in-place.
+// The existing contents of the directory are moved into the payload directory.
+func CreateBag(opts *BagCreateOptions) error {
+	var hashes []xsum.Hash
+	for _, alg := range strings.Split(opts.Algorithm, ",") {
+		hash, err := cli.ParseHash(alg)
+		if err != nil {
+			return err
+		}
+		if strings.ContainsAny(hash.String(), "@/") {
+			return fmt.Errorf("algorithm `%s' not supported for bags", hash)
+		}
+		hashes = append(hashes, hash)
+	}
+	dir := opts.Args.Dir
+	if _, err := os.Stat(filepath.Join(dir, bagDeclaration)); err == nil {
+		return fmt.Errorf("`%s' is already a bag", dir)
+	}
+	if err := movePayload(dir); err != nil {
+		return err
+	}
+	payload, err := walkFiles(filepath.Join(dir, bagPayload))
+	if err != nil {
+		return err
+	}
+	var octets int64
+	for _, p := range payload {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		octets += fi.Size()
+	}
+	var manifests []string
+	for _, hash := range hashes {
+		name := "manifest-" + hash.String() + ".txt"
+		if err := writeBagManifest(dir, name, payload, hash); err != nil {
+			return err
+		}
+		manifests = append(manifests, filepath.Join(dir, name))
+	}
+	if err := os.WriteFile(filepath.Join(dir, bagDeclaration), []byte(
+		"BagIt-Version: 1.0\n"+
+			"Tag-File-Character-Encoding: UTF-8\n",
+	), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, bagInfo), []byte(
+		"Bag-Software-Agent: xsum "+Version+"\n"+
+			"Bagging-Date: "+time.Now().Format("2006-01-02")+"\n"+
+			fmt.Sprintf("Payload-Oxum: %d.%d\n", octets, len(payload)),
+	), 0644); err != nil {
+		return err
+	}
+	tags := append([]string{filepath.Join(dir, bagDeclaration), filepath.Join(dir, bagInfo)}, manifests...)
+	for _, hash := range hashes {
+		if err := writeBagManifest(dir, "tagmanifest-"+hash.String()+".txt", tags, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// movePayload moves all entries in dir into dir/data
+func movePayload(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(dir, ".xsum-bag-")
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(dir, e.Name()), filepath.Join(tmp, e.Name())); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, bagPayload))
+}
+
+// writeBagManifest writes checksums of paths to dir/name, with paths relative to dir
+func writeBagManifest(dir, name string, paths []string, hash xsum.Hash) error {
+	var files []xsum.File
+	for _, p := range paths {
+		files = append(files, xsum.File{Hash: hash, Path: p})
+	}
+	var out strings.Builder
+	sum := &xsum.Sum{}
+	if err := sum.EachList(files, func(n *xsum.Node) error {
+		if n.Err != nil {
+			return n.Err
+		}
+		rel, err := filepath.Rel(dir, n.Path)
+		if err != nil {
+			return err
+		}
+		out.WriteString(n.SumString() + "  " + encodeBagPath(filepath.ToSlash(rel)) + "\n")
+		return nil
+	}); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), []byte(out.String()), 0644)
+}
+
+var (
+	bagPathEncoder = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	bagPathDecoder = strings.NewReplacer("%25", "%", "%0D", "\r", "%0d", "\r", "%0A", "\n", "%0a", "\n")
+)
+
+func encodeBagPath(p string) string {
+	return bagPathEncoder.Replace(p)
+}
+
+func decodeBagPath(p string) string {
+	return bagPathDecoder.Replace(p)
+}
+
+type bagEntry struct {
+	path string // relative to bag, slash-separated
+	sum  string
+}
+
+// ValidateBag checks that every payload file is listed in every payload manifest,
+// that every file listed in a manifest or tag manifest exists, and that all checksums match.
+func ValidateBag(opts *BagValidateOptions) error {
+	dir := opts.Args.Dir
+	if _, err := os.Stat(filepath.Join(dir, bagDeclaration)); err != nil {
+		return fmt.Errorf("invalid bag: %w", err)
+	}
+	manifests, err := filepath.Glob(filepath.Join(dir, "manifest-*.txt"))
+	if err != nil {
+		return err
+	}
+	if len(manifests) == 0 {
+		return errors.New("invalid bag: no payload manifests")
+	}
+	tagManifests, err := filepath.Glob(filepath.Join(dir, "tagmanifest-*.txt"))
+	if err != nil {
+		return err
+	}
+	payload, err := walkFiles(filepath.Join(dir, bagPayload))
+	if err != nil {
+		return fmt.Errorf("invalid bag: %w", err)
+	}
+	payloadSet := map[string]bool{}
+	for _, p := range payload {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		payloadSet[filepath.ToSlash(rel)] = true
+	}
+
+	var (
+		files   []xsum.File
+		sums    []string
+		listed  = map[string]int{}
+		missing = map[string]bool{}
+	)
+	for _, m := range append(manifests, tagManifests...) {
+		isPayload := strings.HasPrefix(filepath.Base(m), "manifest-")
+		hash, entries, err := readBagManifest(m)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if isPayload {
+				if !strings.HasPrefix(e.path, bagPayload+"/") {
+					return fmt.Errorf("invalid bag: `%s' outside of payload in %s", e.path, filepath.Base(m))
+				}
+				listed[e.path]++
+			}
+			p := filepath.Join(dir, filepath.FromSlash(e.path))
+			if _, err := os.Stat(p); err != nil {
+				if !missing[e.path] {
+					fmt.Println(e.path + ": MISSING")
+					missing[e.path] = true
+				}
+				continue
+			}
+			files = append(files, xsum.File{Hash: hash, Path: p})
+			sums = append(sums, e.sum)
+		}
+	}
+	var extra []string
+	for p := range payloadSet {
+		if listed[p] < len(manifests) {
+			extra = append(extra, p)
+		}
+	}
+	sort.Strings(extra)
+	for _, p := range extra {
+		fmt.Println(p + ": NOT IN MANIFEST")
+	}
+
+	failed := 0
+	i := 0
+	sum := &xsum.Sum{}
+	if err := sum.EachList(files, func(n *xsum.Node) error {
+		expected := sums[i]
+		i++
+		rel, err := filepath.Rel(dir, n.Path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if n.Err != nil {
+			log.Printf("xsum: %s", n.Err)
+		}
+		if n.Err != nil || !strings.EqualFold(hex.EncodeToString(n.Sum), expected) {
+			fmt.Println(rel + ": FAILED")
+			failed++
+		} else if !opts.Quiet {
+			fmt.Println(rel + ": OK")
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("%d listed file%s missing", len(missing), plural(len(missing))))
+	}
+	if len(extra) > 0 {
+		problems = append(problems, fmt.Sprintf("%d payload file%s not in manifest", len(extra), plural(len(extra))))
+	}
+	if failed > 0 {
+		problems = append(problems, fmt.Sprintf("%d computed checksum%s did NOT match", failed, plural(failed)))
+	}
+	if err := checkPayloadOxum(dir, payload); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("WARNING: bag is NOT valid: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// readBagManifest parses a manifest or tag manifest, using the algorithm in its name
+func readBagManifest(p string) (xsum.Hash, []bagEntry, error) {
+	name := filepath.Base(p)
+	alg := strings.TrimSuffix(name[strings.Index(name, "-")+1:], ".txt")
+	hash, err := cli.ParseHash(alg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest %s: %w", name, err)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var entries []bagEntry
+	r := bufio.NewReader(f)
+	for eof := false; !eof; {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			eof = true
+		} else if err != nil {
+			return nil, nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			return nil, nil, fmt.Errorf("invalid manifest %s: invalid line `%s'", name, line)
+		}
+		rel := decodeBagPath(strings.TrimLeft(line[i:], " \t"))
+		if path.IsAbs(rel) || rel != path.Clean(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, nil, fmt.Errorf("invalid manifest %s: invalid path `%s'", name, rel)
+		}
+		entries = append(entries, bagEntry{path: rel, sum: line[:i]})
+	}
+	return hash, entries, nil
+}
+
+// checkPayloadOxum compares the Payload-Oxum in bag-info.txt, if present, to the payload
+func checkPayloadOxum(dir string, payload []string) error {
+	b, err := os.ReadFile(filepath.Join(dir, bagInfo))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		const label = "payload-oxum:"
+		if !strings.HasPrefix(strings.ToLower(line), label) {
+			continue
+		}
+		oxum := strings.TrimSpace(line[len(label):])
+		var octets int64
+		for _, p := range payload {
+			fi, err := os.Stat(p)
+			if err != nil {
+				return err
+			}
+			octets += fi.Size()
+		}
+		if actual := strconv.FormatInt(octets, 10) + "." + strconv.Itoa(len(payload)); oxum != actual {
+			return fmt.Errorf("Payload-Oxum %s does NOT match %s", oxum, actual)
+		}
+	}
+	return nil
+}
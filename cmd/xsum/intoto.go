@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/sclevine/xsum"
+)
+
+// See https://github.com/in-toto/attestation/tree/main/spec/v1
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	inTotoPayloadType   = "application/vnd.in-toto+json"
+	inTotoPredicateType = "https://github.com/sclevine/xsum/blob/main/FORMAT.md"
+)
+
+type inTotoStatement struct {
+	Type          string            `json:"_type"`
+	Subject       []inTotoSubject   `json:"subject"`
+	PredicateType string            `json:"predicateType"`
+	Predicate     map[string]string `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name        string            `json:"name"`
+	Digest      map[string]string `json:"digest"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// See https://github.com/secure-systems-lab/dsse/blob/master/envelope.md
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// outputInToto writes an in-toto Statement with a subject for each file.
+// If key is provided, the Statement is wrapped in a signed DSSE envelope.
+func outputInToto(files <-chan xsum.File, format outputFormat, key *secretKey) error {
+	stmt := inTotoStatement{
+		Type:          inTotoStatementType,
+		Subject:       []inTotoSubject{},
+		PredicateType: inTotoPredicateType,
+		Predicate:     map[string]string{"version": Version},
+	}
+	sum := &xsum.Sum{NoDirs: format.basic}
+	if err := sum.Each(files, func(n *xsum.Node) error {
+		if n.Err != nil {
+			log.Printf("xsum: %s", n.Err)
+			return nil
+		}
+		stmt.Subject = append(stmt.Subject, inTotoNodeSubject(n, format))
+		return nil
+	}); err != nil {
+		return err
+	}
+	var out interface{} = stmt
+	if key != nil {
+		env, err := newDSSEEnvelope(stmt, key)
+		if err != nil {
+			return err
+		}
+		out = env
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stdout, "%s\n", b)
+	return err
+}
+
+// inTotoNodeSubject uses standard digest names for checksums of file contents.
+// Checksums that include attributes (e.g., directories) use an xsum-specific digest name and record the mask.
+func inTotoNodeSubject(n *xsum.Node, format outputFormat) inTotoSubject {
+	subj := inTotoSubject{
+		Name:   format.path(n),
+		Digest: map[string]string{},
+	}
+	if n.Mode&os.ModeDir != 0 || n.Mask.Attr&xsum.AttrInclusive != 0 {
+		mask := n.Mask.String()
+		if format.opaque {
+			mask = n.Mask.Hex()
+		}
+		subj.Digest["xsum-"+n.Hash.String()] = n.SumString()
+		subj.Annotations = map[string]string{"mask": mask}
+	} else if format.typed {
+		subj.Digest["xsum-"+n.Hash.String()] = n.SumString()
+	} else {
+		subj.Digest[inTotoDigestName(n.Hash.String())] = n.SumString()
+	}
+	return subj
+}
+
+// See https://github.com/in-toto/attestation/blob/main/spec/v1/digest_set.md
+func inTotoDigestName(alg string) string {
+	switch alg {
+	case xsum.HashRMD160:
+		return "ripemd160"
+	case xsum.HashBlake2b512:
+		return "blake2b"
+	case xsum.HashBlake2s256:
+		return "blake2s"
+	case xsum.HashSHA512_224, xsum.HashSHA512_256,
+		xsum.HashSHA3_224, xsum.HashSHA3_256, xsum.HashSHA3_384, xsum.HashSHA3_512:
+		return strings.ReplaceAll(alg, "-", "_")
+	}
+	return alg
+}
+
+func newDSSEEnvelope(stmt inTotoStatement, key *secretKey) (*dsseEnvelope, error) {
+	payload, err := json.Marshal(stmt)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := dsseKeyID(key)
+	if err != nil {
+		return nil, err
+	}
+	return &dsseEnvelope{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []dsseSignature{{
+			KeyID: keyID,
+			Sig:   base64.StdEncoding.EncodeToString(ed25519.Sign(key.key, dssePAE(inTotoPayloadType, payload))),
+		}},
+	}, nil
+}
+
+// dssePAE returns the DSSE pre-authentication encoding of a payload
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// dsseKeyID returns the minisign/signify key ID or SSH key fingerprint
+func dsseKeyID(key *secretKey) (string, error) {
+	if key.id != nil {
+		return keyIDString(key.id), nil
+	}
+	pub, err := ssh.NewPublicKey(key.key.Public())
+	if err != nil {
+		return "", err
+	}
+	return ssh.FingerprintSHA256(pub), nil
+}
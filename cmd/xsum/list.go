@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sclevine/xsum/cli"
+)
+
+// listAlgorithms writes a table of all available hash functions.
+// Plugins are validated, but invalid plugins are listed with an error instead of failing.
+func listAlgorithms(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tDETAILS")
+	for _, alg := range cli.Algorithms() {
+		var kind string
+		var details []string
+		switch {
+		case alg.Path != "":
+			kind = "plugin"
+			details = append(details, alg.Path+" "+pluginDetails(alg))
+		case alg.Builtin:
+			kind = "built-in"
+		default:
+			kind = "registered"
+		}
+		if alg.Keyed {
+			details = append(details, "keyed")
+		}
+		if len(alg.Aliases) > 0 {
+			details = append(details, "aliases: "+strings.Join(alg.Aliases, ", "))
+		}
+		if len(details) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\n", alg.Name, kind)
+		} else {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", alg.Name, kind, strings.Join(details, "; "))
+		}
+	}
+	return tw.Flush()
+}
+
+func pluginDetails(alg cli.Algorithm) string {
+	info, err := cli.PluginInfo(alg)
+	if err != nil {
+		return fmt.Sprintf("(error: %s)", err)
+	}
+	if info == nil {
+		return "(no plugin info)"
+	}
+	version := info.Version
+	if version != "" && !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	return fmt.Sprintf("(%s, %d-byte checksums, %s)", version, info.Length, strings.Join(info.Types, ", "))
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 
@@ -20,16 +25,33 @@ var Version = "0.0.0"
 type Options struct {
 	General OptionsGeneral `group:"General Options"`
 	Mask    OptionsMask    `group:"Mask Options"`
+	Sign    OptionsSign    `group:"Signature Options"`
+	Plugin  OptionsPlugin  `group:"Plugin Options"`
 	Args    OptionsArgs    `positional-args:"yes"`
 }
 
 type OptionsGeneral struct {
-	Algorithm string `short:"a" long:"algorithm" default:"sha256" description:"Use specified hash function"`
-	Write     string `short:"w" long:"write" optional:"yes" optional-value:"default" description:"Write a separate, adjacent file for each checksum\nBy default, filename will be [orig-name].[alg]\nUse -w=ext or -wext to override extension (no space!)"`
-	Check     bool   `short:"c" long:"check" description:"Validate checksums"`
-	Status    bool   `short:"s" long:"status" description:"With --check, suppress all output"`
-	Quiet     bool   `short:"q" long:"quiet" description:"With --check, suppress passing checksums"`
-	Version   bool   `short:"v" long:"version" description:"Show version"`
+	Algorithm     string `short:"a" long:"algorithm" default:"sha256" description:"Use specified hash function"`
+	ChunkSize     string `long:"chunk-size" description:"Hash each file in concurrent chunks of given size\nUse binary suffixes k, m, g, or t (e.g., 64m)"`
+	Write         string `short:"w" long:"write" optional:"yes" optional-value:"default" description:"Write a separate, adjacent file for each checksum\nBy default, filename will be [orig-name].[alg]\nUse -w=ext or -wext to override extension (no space!)"`
+	WriteMode     string `long:"write-mode" description:"With -w, handle existing files by mode:\nskip\tLeave existing files unchanged (default)\noverwrite\tAtomically replace existing files\nverify\tValidate existing files instead of writing"`
+	Combined      bool   `long:"combined" description:"With -w, write a single manifest for each directory\nBy default, filename will be [ALG]SUMS"`
+	Recursive     bool   `short:"r" long:"recursive" description:"Output a separate checksum for each file in directories"`
+	FilesFrom     string `long:"files-from" description:"Read paths from file, one per line (- for stdin)"`
+	Files0From    string `long:"files0-from" description:"Read NUL-separated paths from file (- for stdin)"`
+	Update        string `long:"update" description:"Update manifest in place, only rehashing modified files\nDeleted files are removed, and unlisted paths are added"`
+	Root          string `long:"root" description:"Write paths relative to given directory"`
+	Format        string `long:"format" description:"Output checksums in alternate format:\nin-toto\tin-toto v1 Statement (DSSE envelope with --sign)\nspdx-json\tSPDX 2.3 JSON (per-file checksums, -a may be comma-separated)\ncyclonedx-json\tCycloneDX 1.5 JSON (per-file checksums, -a may be comma-separated)"`
+	KeyFile       string `long:"key-file" description:"Read key for keyed hash functions (e.g., hmac-sha256) from file"`
+	KeyEnv        string `long:"key-env" description:"Read key for keyed hash functions from environment variable"`
+	Check         bool   `short:"c" long:"check" description:"Validate checksums"`
+	Relative      bool   `long:"relative-to-manifest" description:"With --check, resolve paths relative to each manifest"`
+	Strict        bool   `long:"strict" description:"With --check, fail on malformed entries"`
+	IgnoreMissing bool   `long:"ignore-missing" description:"With --check, skip missing files"`
+	Status        bool   `short:"s" long:"status" description:"With --check or --write-mode=verify, suppress all output"`
+	Quiet         bool   `short:"q" long:"quiet" description:"With --check or --write-mode=verify, suppress passing checksums"`
+	ListAlgs      bool   `long:"list-algorithms" description:"List available hash functions, including plugins"`
+	Version       bool   `short:"v" long:"version" description:"Show version"`
 }
 
 type OptionsMask struct {
@@ -45,10 +67,27 @@ type OptionsMask struct {
 	Opaque     bool   `short:"o" long:"opaque" description:"Encode attribute mask to opaque, fixed-length hex (enables mask)"`
 }
 
+type OptionsSign struct {
+	Key       string `long:"sign" description:"Sign output using minisign, signify, or SSH Ed25519 secret key file"`
+	VerifyKey string `long:"verify-key" description:"With --check, verify each manifest using minisign, signify, or SSH public key file\nNo checksums are validated unless the signature is valid"`
+	Signature string `long:"signature" description:"Write (--sign) or read (--verify-key) detached signature file\nBy default, signatures are appended to manifests as comments"`
+}
+
+type OptionsPlugin struct {
+	Timeout string   `long:"plugin-timeout" description:"Stop plugins after given duration (e.g., 30s)"`
+	Env     []string `long:"plugin-env" description:"Restrict plugin environment to PATH, HOME, locale, temp dirs, and variable (repeatable)\nBy default, plugins inherit all variables. Use --plugin-env= for defaults only"`
+	CPUTime string   `long:"plugin-cpu" description:"Limit CPU time of each plugin (Linux only)"`
+	Memory  string   `long:"plugin-memory" description:"Limit memory of each plugin (e.g., 4g)"`
+}
+
 type OptionsArgs struct {
 	Paths []string `positional-arg-name:"paths"`
 }
 
+type DirArgs struct {
+	Dir string `positional-arg-name:"dir"`
+}
+
 type outputLevel int
 
 const (
@@ -57,11 +96,35 @@ const (
 	outputQuiet
 )
 
+// Subcommand returns arg if it names a subcommand (bag or mtree), or "" otherwise.
+// Existing files take precedence, so that `xsum bag` checksums ./bag if it exists.
+func Subcommand(arg string) string {
+	switch arg {
+	case "bag", "mtree":
+		if _, err := os.Lstat(arg); os.IsNotExist(err) {
+			return arg
+		}
+	}
+	return ""
+}
+
 func main() {
 	log.SetFlags(0)
 
+	if len(os.Args) > 1 {
+		switch Subcommand(os.Args[1]) {
+		case "bag":
+			mainBag(os.Args[2:])
+			return
+		case "mtree":
+			mainMtree(os.Args[2:])
+			return
+		}
+	}
+
 	var opts Options
 	parser := flags.NewParser(&opts, flags.HelpFlag|flags.PassAfterNonOption|flags.PassDoubleDash)
+	parser.Usage = "bag [create | validate] [OPTIONS] dir\n  xsum mtree [OPTIONS] dir\n  xsum [OPTIONS]" // positional args appended
 	rest, err := parser.Parse()
 	if err != nil {
 		if err, ok := err.(*flags.Error); ok && err.Type == flags.ErrHelp {
@@ -73,13 +136,35 @@ func main() {
 		log.Fatalf("Unparsable arguments: %s", strings.Join(rest, ", "))
 	}
 	err = Run(&opts)
-	if err, ok := err.(*InitError); ok && err != nil {
-		log.Fatal(err)
+	if iErr, ok := err.(*InitError); ok && iErr != nil {
+		log.Fatal(iErr)
+	} else if cErr, ok := err.(*CheckError); ok && cErr != nil {
+		for _, w := range cErr.Warnings {
+			log.Printf("xsum: %s", w)
+		}
+		os.Exit(cErr.Code)
 	} else if err != nil {
 		log.Fatalf("xsum: %s", err)
 	}
 }
 
+// parseCommand parses the arguments of a subcommand, exiting on failure
+func parseCommand(name string, data interface{}, args []string) *flags.Parser {
+	parser := flags.NewParser(data, flags.HelpFlag|flags.PassDoubleDash)
+	parser.Name = name
+	rest, err := parser.ParseArgs(args)
+	if err != nil {
+		if err, ok := err.(*flags.Error); ok && err.Type == flags.ErrHelp {
+			log.Fatal(err)
+		}
+		log.Fatalf("Invalid arguments: %s", err)
+	}
+	if len(rest) != 0 {
+		log.Fatalf("Unparsable arguments: %s", strings.Join(rest, ", "))
+	}
+	return parser
+}
+
 func wrapInitError(msg string, err error) error {
 	return &InitError{Msg: msg, Err: err}
 }
@@ -109,6 +194,12 @@ func Run(opts *Options) error {
 		fmt.Printf("xsum v%s\n", Version)
 		return nil
 	}
+	if err := setPluginLimits(&opts.Plugin); err != nil {
+		return err
+	}
+	if opts.General.ListAlgs {
+		return listAlgorithms(os.Stdout)
+	}
 	if multipleTrue(
 		opts.General.Check,
 		opts.Mask.Mask != "",
@@ -132,6 +223,96 @@ func Run(opts *Options) error {
 	if opts.General.Check && opts.General.Write != "" {
 		return newInitError("Only one of -c, -w permitted.")
 	}
+	if opts.General.Check && opts.Sign.Key != "" {
+		return newInitError("Only one of -c, --sign permitted.")
+	}
+	if opts.General.Write != "" && opts.Sign.Key != "" {
+		return newInitError("Only one of -w, --sign permitted.")
+	}
+	switch opts.General.Format {
+	case "", "in-toto", formatSPDX, formatCycloneDX:
+	default:
+		return newInitError(fmt.Sprintf("Invalid format `%s'.", opts.General.Format))
+	}
+	if opts.General.Check && opts.General.Recursive {
+		return newInitError("Only one of -c, -r permitted.")
+	}
+	list, sep := opts.General.FilesFrom, byte('\n')
+	if opts.General.Files0From != "" {
+		list, sep = opts.General.Files0From, 0
+	}
+	if opts.General.FilesFrom != "" && opts.General.Files0From != "" {
+		return newInitError("Only one of --files-from, --files0-from permitted.")
+	}
+	if opts.General.Check && list != "" {
+		return newInitError("Only one of -c, --files-from, --files0-from permitted.")
+	}
+	if list != "" && len(opts.Args.Paths) > 0 {
+		return newInitError("Paths not permitted with --files-from or --files0-from.")
+	}
+	if opts.General.Check && opts.General.Root != "" {
+		return newInitError("Only one of -c, --root permitted.")
+	}
+	switch opts.General.WriteMode {
+	case "", writeSkip, writeOverwrite, writeVerify:
+	default:
+		return newInitError(fmt.Sprintf("Invalid write mode `%s'.", opts.General.WriteMode))
+	}
+	if opts.General.Write == "" && opts.General.WriteMode != "" {
+		return newInitError("Option --write-mode requires -w.")
+	}
+	if opts.General.Write == "" && opts.General.Combined {
+		return newInitError("Option --combined requires -w.")
+	}
+	if opts.General.Write != "" && opts.General.Root != "" {
+		return newInitError("Only one of -w, --root permitted.")
+	}
+	if opts.General.Update != "" && multipleTrue(
+		true,
+		opts.General.Check,
+		opts.General.Write != "",
+		opts.General.Format != "",
+		opts.Sign.Key != "") {
+		return newInitError("Only one of --update, -c, -w, --format, --sign permitted.")
+	}
+	if !opts.General.Check && opts.General.Update == "" && opts.General.Relative {
+		return newInitError("Option --relative-to-manifest requires -c or --update.")
+	}
+	if opts.General.Check && opts.General.Format != "" {
+		return newInitError("Only one of -c, --format permitted.")
+	}
+	if opts.General.Write != "" && opts.General.Format != "" {
+		return newInitError("Only one of -w, --format permitted.")
+	}
+	if opts.General.Format != "" && opts.Sign.Signature != "" {
+		return newInitError("Only one of --format, --signature permitted.")
+	}
+	sbom := opts.General.Format == formatSPDX || opts.General.Format == formatCycloneDX
+	if sbom && opts.Sign.Key != "" {
+		return newInitError(fmt.Sprintf("Only one of --format=%s, --sign permitted.", opts.General.Format))
+	}
+	if sbom && len(opts.Args.Paths) == 0 && list == "" {
+		return newInitError(fmt.Sprintf("Option --format=%s requires paths.", opts.General.Format))
+	}
+	algorithms := strings.Split(opts.General.Algorithm, ",")
+	if len(algorithms) > 1 && !sbom {
+		return newInitError("Multiple algorithms require --format=spdx-json or --format=cyclonedx-json.")
+	}
+	if !opts.General.Check && opts.General.Strict {
+		return newInitError("Option --strict requires -c.")
+	}
+	if !opts.General.Check && opts.General.IgnoreMissing {
+		return newInitError("Option --ignore-missing requires -c.")
+	}
+	if !opts.General.Check && opts.Sign.VerifyKey != "" {
+		return newInitError("Option --verify-key requires -c.")
+	}
+	if opts.Sign.Signature != "" && opts.Sign.Key == "" && opts.Sign.VerifyKey == "" {
+		return newInitError("Option --signature requires --sign or --verify-key.")
+	}
+	if opts.Sign.Signature != "" && opts.Sign.VerifyKey != "" && len(opts.Args.Paths) > 1 {
+		return newInitError("Option --signature requires a single manifest.")
+	}
 
 	level := outputNormal
 	if opts.General.Status {
@@ -139,233 +320,667 @@ func Run(opts *Options) error {
 	} else if opts.General.Quiet {
 		level = outputQuiet
 	}
-	alg, err := cli.ParseHash(opts.General.Algorithm)
+	if opts.General.ChunkSize != "" {
+		for i := range algorithms {
+			algorithms[i] += "@" + opts.General.ChunkSize
+		}
+		opts.General.Algorithm = strings.Join(algorithms, ",")
+	}
+	key, err := readKey(opts.General.KeyFile, opts.General.KeyEnv)
 	if err != nil {
-		return wrapInitError("Invalid algorithm:", err)
+		return wrapInitError("Invalid key:", err)
+	}
+	var hashes []xsum.Hash
+	for _, name := range algorithms {
+		hash, err := cli.ParseKeyedHash(name, key)
+		if err != nil {
+			return wrapInitError("Invalid algorithm:", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	alg := hashes[0]
+	if sbom {
+		if err := checkSBOMHashes(opts.General.Format, hashes); err != nil {
+			return wrapInitError("Invalid algorithm", err)
+		}
 	}
 	if opts.General.Check {
-		return validateChecksums(opts.Args.Paths, alg, level)
+		check := checkOptions{
+			hash:      alg,
+			key:       key,
+			level:     level,
+			signature: opts.Sign.Signature,
+			relative:  opts.General.Relative,
+			strict:    opts.General.Strict,
+			ignore:    opts.General.IgnoreMissing,
+		}
+		if opts.Sign.VerifyKey != "" {
+			check.verifyKey, err = readPublicKey(opts.Sign.VerifyKey)
+			if err != nil {
+				return wrapInitError("Invalid public key", err)
+			}
+		}
+		return validateChecksums(opts.Args.Paths, check)
+	}
+	if key != nil && !isKeyed(alg) {
+		return newInitError("Key provided for unkeyed algorithm.")
 	}
 
-	basic := false
-	var mask xsum.Mask
+	mask, basic, err := parseMask(&opts.Mask)
+	if err != nil {
+		return err
+	}
+	if basic && sbom {
+		mask, basic = xsum.NewMask(00000, xsum.AttrEmpty), false
+	}
+	format := outputFormat{
+		basic:  basic,
+		opaque: opts.Mask.Opaque,
+		typed:  strings.Contains(alg.String(), "@") || isKeyed(alg), // chunked or keyed
+	}
+	if opts.General.Root != "" {
+		format.root, err = filepath.Abs(opts.General.Root)
+		if err != nil {
+			return wrapInitError("Invalid root", err)
+		}
+	}
+	var sk *secretKey
+	if opts.Sign.Key != "" {
+		sk, err = readSecretKey(opts.Sign.Key)
+		if err != nil {
+			return wrapInitError("Invalid secret key", err)
+		}
+	}
+	var listFile io.ReadCloser
+	if list != "" {
+		listFile, err = openPathList(list)
+		if err != nil {
+			return wrapInitError("Invalid file list", err)
+		}
+		defer listFile.Close()
+	}
+	if sbom {
+		paths := opts.Args.Paths
+		if listFile != nil {
+			paths, err = readPathList(listFile, sep)
+			if err != nil {
+				return err
+			}
+		}
+		return outputSBOM(paths, mask, hashes, format, opts.General.Format)
+	}
+	var files <-chan xsum.File
+	if listFile != nil {
+		files = listFiles(listFile, list, sep, mask, alg)
+	} else if opts.General.Update != "" && len(opts.Args.Paths) == 0 {
+		none := make(chan xsum.File)
+		close(none)
+		files = none // no paths to add
+	} else {
+		files = argFiles(opts.Args.Paths, mask, alg)
+	}
+	if opts.General.Recursive {
+		files = expandFiles(files)
+	}
+	if opts.General.Update != "" {
+		return updateManifest(opts.General.Update, files, format, checkOptions{
+			hash:     alg,
+			key:      key,
+			relative: opts.General.Relative,
+		})
+	}
+	if opts.General.Write != "" {
+		if opts.General.Write == "default" {
+			opts.General.Write = opts.General.Algorithm
+		}
+		return writeChecksums(files, format, opts.General.Write, opts.General.WriteMode, opts.General.Combined, level)
+	}
+	if opts.General.Format == "in-toto" {
+		return outputInToto(files, format, sk)
+	}
+	if sk != nil {
+		return signChecksums(files, format, sk, opts.Sign.Signature)
+	}
+	return outputChecksums(os.Stdout, files, format)
+}
+
+// parseMask returns the attribute mask specified by mask options.
+// If no mask options are specified, basic is true.
+func parseMask(opts *OptionsMask) (mask xsum.Mask, basic bool, err error) {
 	switch {
-	case opts.Mask.Mask != "":
-		mask, err = xsum.NewMaskString(opts.Mask.Mask)
+	case opts.Mask != "":
+		mask, err = xsum.NewMaskString(opts.Mask)
 		if err != nil {
-			return wrapInitError("Invalid mask:", err)
+			return mask, false, wrapInitError("Invalid mask:", err)
 		}
-	case opts.Mask.Portable:
+	case opts.Portable:
 		mask = xsum.NewMask(00000, xsum.AttrNoName)
-	case opts.Mask.Git:
+	case opts.Git:
 		mask = xsum.NewMask(00100, xsum.AttrEmpty)
-	case opts.Mask.Full:
+	case opts.Full:
 		mask = xsum.NewMask(07777, xsum.AttrUID|xsum.AttrGID)
-	case opts.Mask.Extended:
+	case opts.Extended:
 		mask = xsum.NewMask(07777, xsum.AttrUID|xsum.AttrGID|xsum.AttrX|xsum.AttrSpecial)
-	case opts.Mask.Everything:
+	case opts.Everything:
 		mask = xsum.NewMask(07777, xsum.AttrUID|xsum.AttrGID|xsum.AttrX|xsum.AttrSpecial|xsum.AttrCtime|xsum.AttrMtime)
-	case opts.Mask.Directory, opts.Mask.Inclusive, opts.Mask.Follow, opts.Mask.Opaque: // inclusive+follow+opaque must be last on this list
+	case opts.Directory, opts.Inclusive, opts.Follow, opts.Opaque: // inclusive+follow+opaque must be last on this list
 		mask = xsum.NewMask(00000, xsum.AttrEmpty)
 	default:
 		basic = true
 	}
-	if opts.Mask.Inclusive {
+	if opts.Inclusive {
 		mask.Attr |= xsum.AttrInclusive
 	}
-	if opts.Mask.Follow {
+	if opts.Follow {
 		mask.Attr |= xsum.AttrFollow
 	}
-	if opts.General.Write != "" {
-		if opts.General.Write == "default" {
-			opts.General.Write = opts.General.Algorithm
-		}
-		return writeChecksums(opts.Args.Paths, mask, alg, basic, opts.Mask.Opaque, opts.General.Write)
+	return mask, basic, nil
+}
+
+type outputFormat struct {
+	basic  bool   // no mask, directories rejected
+	opaque bool   // fixed-length hex mask
+	typed  bool   // checksum type required even with basic
+	root   string // absolute directory that paths are written relative to
+}
+
+// path returns the path written for a node
+func (f outputFormat) path(n *xsum.Node) string {
+	if f.root == "" || n.Stdin {
+		return filepath.ToSlash(n.Path)
 	}
-	return outputChecksums(opts.Args.Paths, mask, alg, basic, opts.Mask.Opaque)
+	abs, err := filepath.Abs(n.Path)
+	if err != nil {
+		return filepath.ToSlash(n.Path)
+	}
+	rel, err := filepath.Rel(f.root, abs)
+	if err != nil {
+		return filepath.ToSlash(n.Path)
+	}
+	return filepath.ToSlash(rel)
 }
 
-func outputChecksums(paths []string, mask xsum.Mask, hash xsum.Hash, basic, opaque bool) error {
-	sum := &xsum.Sum{NoDirs: basic}
-	files := convertToFiles(paths, mask, hash)
-	return sum.EachList(files, func(n *xsum.Node) error {
+func outputChecksums(w io.Writer, files <-chan xsum.File, format outputFormat) error {
+	sum := &xsum.Sum{NoDirs: format.basic}
+	return sum.Each(files, func(n *xsum.Node) error {
 		if n.Err != nil {
 			log.Printf("xsum: %s", n.Err)
 			return nil
 		}
-		fmt.Println(formatChecksum(n, basic, opaque))
-		return nil
+		_, err := fmt.Fprintln(w, formatChecksum(n, format))
+		return err
 	})
 }
 
-func formatChecksum(n *xsum.Node, basic, opaque bool) string {
+// signChecksums signs exactly the checksums written to stdout.
+// If sigPath is empty, the signature is appended to stdout as comments.
+func signChecksums(files <-chan xsum.File, format outputFormat, key *secretKey, sigPath string) error {
+	s := newSigner(key)
+	if err := outputChecksums(io.MultiWriter(os.Stdout, s), files, format); err != nil {
+		return err
+	}
+	sig, err := s.Sign()
+	if err != nil {
+		return err
+	}
+	if sigPath != "" {
+		return os.WriteFile(sigPath, sig, 0644)
+	}
+	_, err = os.Stdout.Write(inlineSignature(sig))
+	return err
+}
+
+// formatChecksum formats an entry for n.
+// Like coreutils, entries with paths that contain backslashes or line breaks are prefixed with a backslash, and the path is escaped.
+func formatChecksum(n *xsum.Node, format outputFormat) string {
+	path, escaped := escapePath(format.path(n))
+	var entry string
 	switch {
-	case basic:
-		return n.SumString() + "  " + filepath.ToSlash(n.Path)
-	case opaque:
-		return n.Hex() + "  " + filepath.ToSlash(n.Path)
+	case format.basic && !format.typed:
+		entry = n.SumString() + "  " + path
+	case format.opaque:
+		entry = n.Hex() + "  " + path
 	default:
-		return n.String() + "  " + filepath.ToSlash(n.Path)
+		entry = n.String() + "  " + path
+	}
+	if escaped {
+		return `\` + entry
 	}
+	return entry
 }
 
-func writeChecksums(paths []string, mask xsum.Mask, hash xsum.Hash, basic, opaque bool, ext string) error {
-	sum := &xsum.Sum{NoDirs: basic}
-	files := convertToFiles(paths, mask, hash)
-	return sum.EachList(files, func(n *xsum.Node) error {
-		if n.Err != nil {
-			log.Printf("xsum: %s", n.Err)
-			return nil
-		}
-		if n.Stdin {
-			log.Print("xsum: skipping standard input")
-			return nil
-		}
-		if ext == "" {
-			ext = hash.String()
-		}
-		abs, err := filepath.Abs(n.Path)
-		if err != nil {
-			log.Printf("xsum: %s", n.Err)
-			return nil
+// escapePath escapes backslashes and line breaks in path, and returns true if path required escaping.
+func escapePath(path string) (string, bool) {
+	if !strings.ContainsAny(path, "\\\n\r") {
+		return path, false
+	}
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(path), true
+}
+
+// checkedPath formats path for -c output, escaping it like an entry if necessary.
+func checkedPath(path string) string {
+	if path, escaped := escapePath(path); escaped {
+		return `\` + path
+	}
+	return path
+}
+
+// splitEscaped removes the backslash prefix from an entry with an escaped path, and returns true if it was present.
+func splitEscaped(entry string) (string, bool) {
+	if strings.HasPrefix(entry, `\`) {
+		return entry[1:], true
+	}
+	return entry, false
+}
+
+// unescapePath reverses escapePath, and returns false if path contains an invalid escape sequence.
+func unescapePath(path string) (string, bool) {
+	if !strings.Contains(path, `\`) {
+		return path, true
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] != '\\' {
+			b.WriteByte(path[i])
+			continue
 		}
-		f, err := os.OpenFile(abs+"."+ext, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0777)
-		if err != nil {
-			log.Printf("xsum: %s", err)
-			return nil
+		if i++; i == len(path) {
+			return "", false
 		}
-		if _, err := fmt.Fprintln(f, formatChecksum(n, basic, opaque)); err != nil {
-			f.Close()
-			log.Printf("xsum: %s", err)
-			return nil
+		switch path[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			return "", false
 		}
-		if err := f.Close(); err != nil {
-			log.Printf("xsum: %s", err)
-			return nil
+	}
+	return b.String(), true
+}
+
+type checkOptions struct {
+	hash      xsum.Hash // used for entries without a checksum type
+	key       []byte    // if present, unkeyed entries are rejected, and otherwise keyed entries are rejected
+	verifyKey *publicKey
+	signature string // detached signature file
+	relative  bool   // resolve paths relative to manifests
+	strict    bool   // fail on malformed entries
+	ignore    bool   // skip missing files
+	level     outputLevel
+	counts    *checkCounts // if present, rejected entries and manifests are counted
+}
+
+type checkCounts struct {
+	ok, warned, failed, missing, unreadable, malformed, skipped int
+	rejected                                                    int // counted as failed
+}
+
+func (o checkOptions) malformed(format string, v ...interface{}) {
+	log.Printf(format, v...)
+	if o.counts != nil {
+		o.counts.malformed++
+	}
+}
+
+// rejected fails an entry that cannot be checked, because its checksum is unkeyed and a key is provided, or keyed and no key is provided.
+// Rejected entries always fail, so that a manifest cannot be rewritten to avoid verification with a key.
+func (o checkOptions) rejected(path, reason string) {
+	log.Printf("xsum: %s", reason)
+	if o.counts != nil {
+		if o.level != outputStatus {
+			fmt.Println(checkedPath(path) + ": FAILED")
 		}
-		return nil
-	})
+		o.counts.rejected++
+	}
 }
 
-func validateChecksums(indexes []string, hash xsum.Hash, level outputLevel) error {
+// keyRejection returns the reason an entry with the provided hash must be rejected, or an empty string if it may be checked.
+func (o checkOptions) keyRejection(index, path string, hash xsum.Hash, err error) string {
+	switch {
+	case errors.Is(err, cli.ErrKeyRequired):
+		return fmt.Sprintf("%s: keyed checksum rejected for `%s' without a key", index, path)
+	case err == nil && o.key != nil && !isKeyed(hash):
+		return fmt.Sprintf("%s: unkeyed algorithm `%s' rejected for `%s'", index, hash, path)
+	}
+	return ""
+}
+
+func (o checkOptions) unreadable(format string, v ...interface{}) {
+	log.Printf(format, v...)
+	if o.counts != nil {
+		o.counts.unreadable++
+	}
+}
+
+// Exit codes returned by -c
+const (
+	ExitFailed     = 1 // computed checksums did not match
+	ExitIO         = 2 // files or manifests were missing or unreadable
+	ExitMalformed  = 3 // entries were malformed (with --strict)
+	ExitUnverified = 4 // manifest signatures could not be verified
+)
+
+// CheckError is returned when checksums cannot be validated.
+// Code is the exit code for the most severe problem, and Warnings describe each problem.
+type CheckError struct {
+	Code     int
+	Warnings []string
+}
+
+func (e *CheckError) Error() string {
+	return strings.Join(e.Warnings, "\n")
+}
+
+func validateChecksums(indexes []string, opts checkOptions) error {
 	files := make(chan xsum.File, 1)
 	sums := make(chan string, 1)
+	counts := &checkCounts{}
+	opts.counts = counts
+	unverified := 0
 	go func() {
 		defer close(files)
+		fn := func(f xsum.File, sum string) {
+			files <- f
+			sums <- sum
+		}
 		if len(indexes) == 0 {
-			readIndexStdin(hash, func(f xsum.File, sum string) {
-				files <- f
-				sums <- sum
-			})
-			return
+			indexes = []string{"-"}
 		}
+		indexes = expandIndexes(indexes)
 		for _, path := range indexes {
+			var err error
 			switch path {
 			case "-":
-				readIndexStdin(hash, func(f xsum.File, sum string) {
-					files <- f
-					sums <- sum
-				})
+				err = readIndexStdin(opts, fn)
 			default:
-				readIndexPath(path, hash, func(f xsum.File, sum string) {
-					files <- f
-					sums <- sum
-				})
+				err = readIndexPath(path, opts, fn)
+			}
+			if err != nil {
+				log.Printf("xsum: %s", err)
+				unverified++
 			}
 		}
 	}()
-	failed := 0
 	sum := &xsum.Sum{}
 	if err := sum.Each(files, func(n *xsum.Node) error {
-		if n.Err != nil {
+		expected := <-sums
+		var result string
+		switch {
+		case errors.Is(n.Err, fs.ErrNotExist) && opts.ignore:
+			counts.skipped++
+			return nil
+		case errors.Is(n.Err, fs.ErrNotExist):
+			result = "MISSING"
+			counts.missing++
+		case n.Err != nil:
 			log.Printf("xsum: %s", n.Err)
-		}
-		if hex.EncodeToString(n.Sum) != <-sums {
-			if level != outputStatus {
-				fmt.Println(n.Path + ": FAILED")
-			}
-			failed++
-		} else {
-			if level != outputStatus && level != outputQuiet {
-				fmt.Println(n.Path + ": OK")
+			result = "UNREADABLE"
+			counts.unreadable++
+		case hex.EncodeToString(n.Sum) != expected:
+			result = "FAILED"
+			counts.failed++
+		case len(n.Warnings) > 0:
+			result = "WARNED (" + warningCategories(n.Warnings) + ")"
+			counts.warned++
+		default:
+			counts.ok++
+			if opts.level != outputStatus && opts.level != outputQuiet {
+				fmt.Println(checkedPath(n.Path) + ": OK")
 			}
+			return nil
+		}
+		if opts.level != outputStatus {
+			fmt.Println(checkedPath(n.Path) + ": " + result)
 		}
 		return nil
 	}); err != nil {
 		return err
 	}
-	if failed > 0 {
-		plural := ""
-		if failed > 1 {
-			plural = "s"
+	counts.failed += counts.rejected
+	if opts.level != outputStatus {
+		fmt.Fprintf(os.Stderr, "%d OK, %d WARNED, %d FAILED, %d MISSING, %d UNREADABLE, %d MALFORMED, %d SKIPPED\n",
+			counts.ok, counts.warned, counts.failed, counts.missing, counts.unreadable, counts.malformed, counts.skipped)
+	}
+
+	cErr := &CheckError{}
+	warn := func(code, n int, msg string) {
+		if n == 0 {
+			return
+		}
+		if cErr.Code == 0 || severity(code) > severity(cErr.Code) {
+			cErr.Code = code
 		}
-		if level == outputStatus {
-			os.Exit(1)
+		if opts.level != outputStatus {
+			cErr.Warnings = append(cErr.Warnings, fmt.Sprintf(msg, n, plural(n)))
 		}
-		return fmt.Errorf("WARNING: %d computed checksum%s did NOT match", failed, plural)
+	}
+	warn(ExitUnverified, unverified, "WARNING: %d manifest signature%s could NOT be verified")
+	warn(ExitFailed, counts.failed-counts.rejected, "WARNING: %d computed checksum%s did NOT match")
+	warn(ExitFailed, counts.rejected, "WARNING: %d checksum%s rejected due to the presence or absence of a key")
+	warn(ExitIO, counts.missing, "WARNING: %d listed file%s could NOT be found")
+	warn(ExitIO, counts.unreadable, "WARNING: %d listed file%s or manifest%[2]s could NOT be read")
+	if opts.strict {
+		warn(ExitMalformed, counts.malformed, "WARNING: %d malformed line%s")
+	}
+	if cErr.Code != 0 {
+		return cErr
 	}
 	return nil
 }
 
-func readIndexPath(path string, hash xsum.Hash, fn func(xsum.File, string)) {
+// warningCategories lists the distinct categories of plugin warnings, for checksums that matched with warnings.
+func warningCategories(warnings []xsum.PluginWarning) string {
+	var categories []string
+	seen := map[string]bool{}
+	for _, w := range warnings {
+		category := w.Category
+		if category == "" {
+			category = "warning"
+		}
+		if !seen[category] {
+			seen[category] = true
+			categories = append(categories, category)
+		}
+	}
+	return strings.Join(categories, ", ")
+}
+
+// severity orders exit codes by precedence.
+// Unverified signatures are most severe, because checksums in unverified manifests are never validated.
+// Otherwise, lower exit codes are more severe.
+func severity(code int) int {
+	if code == ExitUnverified {
+		return 0
+	}
+	return -code
+}
+
+func plural(n int) string {
+	if n > 1 {
+		return "s"
+	}
+	return ""
+}
+
+// expandIndexes replaces directories with the SFV and per-directory checksum files they contain
+func expandIndexes(indexes []string) []string {
+	var out []string
+	for _, path := range indexes {
+		if fi, err := os.Stat(path); path == "-" || err != nil || !fi.IsDir() {
+			out = append(out, path)
+			continue
+		}
+		sidecars, err := findSidecars(path)
+		if err != nil {
+			log.Printf("xsum: %s", err)
+		}
+		out = append(out, sidecars...)
+	}
+	return out
+}
+
+// readIndexPath only returns an error if the signature of the index cannot be verified
+func readIndexPath(path string, opts checkOptions, fn func(xsum.File, string)) error {
 	f, err := os.Open(path)
 	if err != nil {
-		log.Printf("xsum: %s", err)
-		return
+		opts.unreadable("xsum: %s", err)
+		return nil
 	}
 	defer f.Close()
-	readIndex(f, path, hash, fn)
+	return readIndexVerified(f, path, filepath.Dir(path), opts, fn)
 }
 
-func readIndexStdin(hash xsum.Hash, fn func(xsum.File, string)) {
-	readIndex(os.Stdin, "standard input", hash, fn)
+// readIndexStdin only returns an error if the signature of the index cannot be verified
+func readIndexStdin(opts checkOptions, fn func(xsum.File, string)) error {
+	return readIndexVerified(os.Stdin, "standard input", "", opts, fn)
 }
 
-func readIndex(f *os.File, path string, hash xsum.Hash, fn func(xsum.File, string)) {
-	scan := bufio.NewScanner(f)
+func readIndexVerified(r io.Reader, path, dir string, opts checkOptions, fn func(xsum.File, string)) error {
+	if opts.verifyKey == nil {
+		readIndexFormat(r, path, dir, opts, fn)
+		return nil
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		opts.unreadable("xsum: %s: %s", path, err)
+		return nil
+	}
+	msg, sig := splitInlineSignature(b)
+	if opts.signature != "" {
+		msg = b
+		if sig, err = os.ReadFile(opts.signature); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if sig == nil {
+		return fmt.Errorf("%s: missing signature", path)
+	}
+	if err := verifySignature(opts.verifyKey, msg, sig); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	readIndexFormat(bytes.NewReader(msg), path, dir, opts, fn)
+	return nil
+}
+
+// If opts.key is provided, readIndex rejects entries that do not use keyed hash functions.
+// If dir is provided, relative paths are resolved relative to dir if opts.relative is set or the index is a sidecar file.
+func readIndex(r io.Reader, path, dir string, opts checkOptions, fn func(xsum.File, string)) {
+	scan := bufio.NewScanner(r)
 	for scan.Scan() {
 		entry := scan.Text()
 		if s := strings.TrimSpace(entry); len(s) > 0 && s[0] == '#' {
 			continue
 		}
-		lines := strings.SplitN(entry, "  ", 2)
+		hash := opts.hash // checksum types only apply to their own entry
+		line, escaped := splitEscaped(entry)
+		lines := strings.SplitN(line, "  ", 2)
 		if len(lines) != 2 {
-			log.Printf("xsum: %s: invalid entry `%s'", path, entry)
+			opts.malformed("xsum: %s: invalid entry `%s'", path, entry)
 			continue
 		}
 		fhash := lines[0]
 		fpath := lines[1]
+		if escaped {
+			var ok bool
+			if fpath, ok = unescapePath(fpath); !ok {
+				opts.malformed("xsum: %s: invalid escaped path in entry `%s'", path, entry)
+				continue
+			}
+		}
 
 		var mask xsum.Mask
 
-		if p := strings.SplitN(fhash, ":", 3); len(p) > 1 {
-			var err error
-			hash, err = cli.ParseHash(p[0])
-			if err != nil {
-				log.Printf("xsum: %s: invalid algorithm: %s", path, err)
+		var err error
+		if isTypedSum(fhash) {
+			hash, fhash, mask, err = parseTypedSum(fhash, opts.key)
+			if err != nil && !errors.Is(err, cli.ErrKeyRequired) {
+				opts.malformed("xsum: %s: %s", path, err)
 				continue
 			}
-			fhash = p[1]
-			if len(p) > 2 {
-				if len(p[2]) > 4 && p[2][4] != '+' {
-					mask, err = xsum.NewMaskHex(p[2])
-					if err != nil {
-						log.Printf("xsum: %s: invalid hex mask: %s", path, err)
-						continue
-					}
-				} else {
-					mask, err = xsum.NewMaskString(p[2])
-					if err != nil {
-						log.Printf("xsum: %s: invalid mask: %s", path, err)
-						continue
-					}
-				}
-			}
+		}
+		reject := opts.keyRejection(path, fpath, hash, err)
+		if dir != "" && !filepath.IsAbs(fpath) && (opts.relative || isSidecarOf(path, fpath)) {
+			fpath = filepath.Join(dir, fpath)
+		}
+		if reject != "" {
+			opts.rejected(fpath, reject)
+			continue
 		}
 		fn(xsum.File{Hash: hash, Path: fpath, Mask: mask}, strings.ToLower(fhash))
 	}
 }
 
+// isTypedSum returns true if sum is of the form: alg:HEX[:mask]
+func isTypedSum(sum string) bool {
+	return strings.Contains(sum, ":")
+}
+
+// parseTypedSum parses checksums of the form: alg:HEX[:mask]
+func parseTypedSum(sum string, key []byte) (hash xsum.Hash, fhash string, mask xsum.Mask, err error) {
+	p := strings.SplitN(sum, ":", 3)
+	hash, err = cli.ParseKeyedHash(p[0], key)
+	if err != nil {
+		return nil, "", mask, fmt.Errorf("invalid algorithm: %w", err)
+	}
+	if len(p) > 2 {
+		if len(p[2]) > 4 && p[2][4] != '+' {
+			mask, err = xsum.NewMaskHex(p[2])
+			if err != nil {
+				return nil, "", mask, fmt.Errorf("invalid hex mask: %w", err)
+			}
+		} else {
+			mask, err = xsum.NewMaskString(p[2])
+			if err != nil {
+				return nil, "", mask, fmt.Errorf("invalid mask: %w", err)
+			}
+		}
+	}
+	return hash, p[1], mask, nil
+}
+
+// isSidecarOf returns true if index is named [path].[ext], as written by -w
+func isSidecarOf(index, path string) bool {
+	base := filepath.Base(index)
+	return strings.TrimSuffix(base, filepath.Ext(base)) == path
+}
+
+func readKey(file, env string) ([]byte, error) {
+	switch {
+	case file != "" && env != "":
+		return nil, errors.New("only one of --key-file, --key-env permitted")
+	case file != "":
+		key, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		// only a single line ending is removed, so that binary keys are used exactly as stored
+		if bytes.HasSuffix(key, []byte("\r\n")) {
+			key = key[:len(key)-2]
+		} else if bytes.HasSuffix(key, []byte("\n")) {
+			key = key[:len(key)-1]
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("key file `%s' is empty", file)
+		}
+		return key, nil
+	case env != "":
+		key := os.Getenv(env)
+		if key == "" {
+			return nil, fmt.Errorf("environment variable `%s' is empty", env)
+		}
+		return []byte(key), nil
+	}
+	return nil, nil
+}
+
+func isKeyed(hash xsum.Hash) bool {
+	_, err := cli.ParseHash(hash.String())
+	return errors.Is(err, cli.ErrKeyRequired)
+}
+
 func convertToFiles(paths []string, mask xsum.Mask, hash xsum.Hash) []xsum.File {
 	var out []xsum.File
 	if len(paths) == 0 {
@@ -391,6 +1006,84 @@ func convertToFiles(paths []string, mask xsum.Mask, hash xsum.Hash) []xsum.File
 	return out
 }
 
+// argFiles sends a File for each path argument, or for standard input if there are no arguments
+func argFiles(paths []string, mask xsum.Mask, hash xsum.Hash) <-chan xsum.File {
+	ch := make(chan xsum.File, 1)
+	go func() {
+		defer close(ch)
+		for _, f := range convertToFiles(paths, mask, hash) {
+			ch <- f
+		}
+	}()
+	return ch
+}
+
+// expandFiles replaces each directory with the files it contains.
+// Symlinks and special files are handled as if they were top-level files.
+func expandFiles(files <-chan xsum.File) <-chan xsum.File {
+	ch := make(chan xsum.File, 1)
+	go func() {
+		defer close(ch)
+		for f := range files {
+			if f.Stdin {
+				ch <- f
+				continue
+			}
+			inclusive := f.Mask.Attr&xsum.AttrInclusive != 0
+			follow := f.Mask.Attr&xsum.AttrFollow != 0 || !inclusive
+			walk(f.Path, walkOptions{follow: follow, followDirs: follow}, func(path string, fi os.FileInfo, err error) error {
+				switch {
+				case fi != nil && fi.Mode()&os.ModeSymlink != 0:
+					// unfollowed symlinks are hashed as symlinks, and unresolved symlinks are reported when hashed
+				case err != nil:
+					log.Printf("xsum: %s", err)
+					return nil
+				case fi.IsDir():
+					return nil
+				case !fi.Mode().IsRegular() && !inclusive:
+					log.Printf("xsum: %s: skipping special file", path)
+					return nil
+				}
+				f.Path = path
+				ch <- f
+				return nil
+			})
+		}
+	}()
+	return ch
+}
+
+// setPluginLimits restricts plugins selected by name to the limits in opts.
+func setPluginLimits(opts *OptionsPlugin) error {
+	var limits xsum.PluginLimits
+	var err error
+	if opts.Timeout != "" {
+		if limits.Timeout, err = time.ParseDuration(opts.Timeout); err != nil {
+			return wrapInitError("Invalid plugin timeout", err)
+		}
+	}
+	if opts.CPUTime != "" {
+		if limits.CPUTime, err = time.ParseDuration(opts.CPUTime); err != nil {
+			return wrapInitError("Invalid plugin CPU time", err)
+		}
+	}
+	if opts.Memory != "" {
+		if limits.Memory, err = xsum.ParseSize(opts.Memory); err != nil {
+			return wrapInitError("Invalid plugin memory", err)
+		}
+	}
+	if opts.Env != nil {
+		limits.Env = append([]string{}, xsum.DefaultPluginEnv...)
+		for _, name := range opts.Env {
+			if name != "" {
+				limits.Env = append(limits.Env, name)
+			}
+		}
+	}
+	cli.SetPluginLimits(limits)
+	return nil
+}
+
 func multipleTrue(b ...bool) bool {
 	var r bool
 	for _, v := range b {
@@ -2,14 +2,28 @@ package main_test
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
 
 	main "github.com/sclevine/xsum/cmd/xsum"
 )
@@ -131,3 +145,1023 @@ func TestRun_shasum(t *testing.T) {
 		t.Fatalf("lower(xsum(./*)) =\n%sexpected:\n%s", result, expected)
 	}
 }
+
+func TestRun_keyed(t *testing.T) {
+	discardStdout(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "a", "key": "secret\n", "wrong": "wrong\n"})
+	b, err := captureStdout(t, func() error {
+		return main.Run(&main.Options{
+			General: main.OptionsGeneral{Algorithm: "hmac-sha256", KeyFile: filepath.Join(dir, "key"), Root: dir},
+			Args:    main.OptionsArgs{Paths: []string{filepath.Join(dir, "a")}},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("a"))
+	if expected := "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)) + "  a\n"; string(b) != expected {
+		t.Errorf("Unexpected output: %s", b)
+	}
+	manifest := filepath.Join(dir, "SUMS")
+	if err := os.WriteFile(manifest, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	check := func(key string, manifests ...string) error {
+		if manifests == nil {
+			manifests = []string{manifest}
+		}
+		return main.Run(&main.Options{
+			General: main.OptionsGeneral{Algorithm: "sha256", Check: true, Relative: true, KeyFile: key},
+			Args:    main.OptionsArgs{Paths: manifests},
+		})
+	}
+	if err := check(filepath.Join(dir, "key")); err != nil {
+		t.Error(err)
+	}
+	if err := check(filepath.Join(dir, "wrong")); err == nil {
+		t.Error("Expected failure with wrong key")
+	}
+
+	// keyed entries must not be checked without a key
+	var cErr *main.CheckError
+	if err := check(""); !errors.As(err, &cErr) || cErr.Code != main.ExitFailed {
+		t.Errorf("Expected failure without key, got: %v", err)
+	}
+
+	// unkeyed entries must not be accepted in place of keyed entries
+	sum := sha256.Sum256([]byte("a"))
+	writeFiles(t, dir, map[string]string{
+		"UNKEYED":     hex.EncodeToString(sum[:]) + "  a\n",
+		"sums.sha256": hex.EncodeToString(sum[:]) + "  a\n",
+	})
+	for _, name := range []string{"UNKEYED", "sums.sha256"} {
+		if err := check(filepath.Join(dir, "key"), filepath.Join(dir, name)); !errors.As(err, &cErr) || cErr.Code != main.ExitFailed {
+			t.Errorf("%s: expected failure for unkeyed entries with key, got: %v", name, err)
+		}
+	}
+	if err := check(filepath.Join(dir, "missing")); err == nil || !strings.HasPrefix(err.Error(), "Invalid key:") {
+		t.Errorf("Unexpected error for missing key: %v", err)
+	}
+
+	// only a single line ending is removed from key files
+	for content, key := range map[string]string{
+		"secret\r\n":     "secret",
+		"secret\n\n":     "secret\n",
+		"secret\r":       "secret\r",
+		"\x00\n\r\x00\n": "\x00\n\r\x00",
+	} {
+		writeFiles(t, dir, map[string]string{"key": content})
+		b, err := captureStdout(t, func() error {
+			return main.Run(&main.Options{
+				General: main.OptionsGeneral{Algorithm: "hmac-sha256", KeyFile: filepath.Join(dir, "key"), Root: dir},
+				Args:    main.OptionsArgs{Paths: []string{filepath.Join(dir, "a")}},
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte("a"))
+		if expected := "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)) + "  a\n"; string(b) != expected {
+			t.Errorf("Unexpected output for key %q: %s", content, b)
+		}
+	}
+}
+
+func TestRun_sign(t *testing.T) {
+	pub, sk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := []byte("keyid123")
+	dir := t.TempDir()
+
+	chk, _ := blake2b.New256(nil)
+	chk.Write([]byte("Ed"))
+	chk.Write(id)
+	chk.Write(sk)
+	minisignKey := bl("Ed", []byte{0, 0}, "B2", make([]byte, 48), id, sk, chk.Sum(nil))
+	skSum := sha512.Sum512(sk)
+	signifyKey := bl("Ed", "BK", make([]byte, 20), skSum[:8], id, sk)
+	pubKey := writeCommented(t, dir, "key.pub", bl("Ed", id, pub))
+
+	for name, key := range map[string][]byte{
+		"minisign": minisignKey,
+		"signify":  signifyKey,
+	} {
+		secKey := writeCommented(t, dir, name+".key", key)
+		for _, detached := range []bool{false, true} {
+			manifest := filepath.Join(dir, "manifest")
+			var sigPath string
+			if detached {
+				sigPath = filepath.Join(dir, "manifest.sig")
+			}
+			b, err := captureStdout(t, func() error {
+				return main.Run(&main.Options{
+					General: main.OptionsGeneral{Algorithm: "sha256"},
+					Sign:    main.OptionsSign{Key: secKey, Signature: sigPath},
+					Args:    main.OptionsArgs{Paths: []string{"main.go"}},
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(manifest, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			check := &main.Options{
+				General: main.OptionsGeneral{Algorithm: "sha256", Check: true, Quiet: true},
+				Sign:    main.OptionsSign{VerifyKey: pubKey, Signature: sigPath},
+				Args:    main.OptionsArgs{Paths: []string{manifest}},
+			}
+			if err := main.Run(check); err != nil {
+				t.Errorf("xsum -c [%s, detached=%t] error: %s", name, detached, err)
+			}
+			// comments are ignored by -c, so only the signature can detect this
+			if err := os.WriteFile(manifest, append([]byte("# modified\n"), b...), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := main.Run(check); err == nil {
+				t.Errorf("xsum -c [%s, detached=%t] verified modified manifest", name, detached)
+			}
+			if detached {
+				continue
+			}
+
+			// an unverified signature takes precedence over mismatched checksums in another manifest
+			other := filepath.Join(dir, "other")
+			writeFiles(t, dir, map[string]string{"other": "a"})
+			b, err = captureStdout(t, func() error {
+				return main.Run(&main.Options{
+					General: main.OptionsGeneral{Algorithm: "sha256"},
+					Sign:    main.OptionsSign{Key: secKey},
+					Args:    main.OptionsArgs{Paths: []string{other}},
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(other+".sha256", b, 0644); err != nil {
+				t.Fatal(err)
+			}
+			writeFiles(t, dir, map[string]string{"other": "b"})
+			check.Args.Paths = []string{other + ".sha256", manifest}
+			err = main.Run(check)
+			if cErr, ok := err.(*main.CheckError); !ok || cErr.Code != main.ExitUnverified {
+				t.Errorf("xsum -c [%s] expected exit code %d, got: %v", name, main.ExitUnverified, err)
+			}
+		}
+	}
+}
+
+func TestRun_inToto(t *testing.T) {
+	pub, sk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	id := []byte("keyid123")
+	chk, _ := blake2b.New256(nil)
+	chk.Write([]byte("Ed"))
+	chk.Write(id)
+	chk.Write(sk)
+	secKey := writeCommented(t, dir, "minisign.key", bl("Ed", []byte{0, 0}, "B2", make([]byte, 48), id, sk, chk.Sum(nil)))
+
+	b, err := captureStdout(t, func() error {
+		return main.Run(&main.Options{
+			General: main.OptionsGeneral{Algorithm: "sha256", Format: "in-toto"},
+			Mask:    main.OptionsMask{Directory: true},
+			Sign:    main.OptionsSign{Key: secKey},
+			Args:    main.OptionsArgs{Paths: []string{"main.go", "."}},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var env struct {
+		PayloadType string
+		Payload     []byte
+		Signatures  []struct {
+			KeyID string
+			Sig   []byte
+		}
+	}
+	if err := json.Unmarshal(b, &env); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.Signatures) != 1 || env.Signatures[0].KeyID != "333231646979656B" {
+		t.Fatalf("Unexpected signatures: %+v", env.Signatures)
+	}
+	pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(env.PayloadType), env.PayloadType, len(env.Payload), env.Payload)
+	if !ed25519.Verify(pub, []byte(pae), env.Signatures[0].Sig) {
+		t.Fatal("Invalid DSSE signature")
+	}
+
+	var stmt struct {
+		Type    string `json:"_type"`
+		Subject []struct {
+			Name        string
+			Digest      map[string]string
+			Annotations map[string]string
+		}
+	}
+	if err := json.Unmarshal(env.Payload, &stmt); err != nil {
+		t.Fatal(err)
+	}
+	if stmt.Type != "https://in-toto.io/Statement/v1" || len(stmt.Subject) != 2 {
+		t.Fatalf("Unexpected statement: %+v", stmt)
+	}
+	data, err := os.ReadFile("main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(data); stmt.Subject[0].Name != "main.go" ||
+		stmt.Subject[0].Digest["sha256"] != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected file subject: %+v", stmt.Subject[0])
+	}
+	if stmt.Subject[1].Name != "." ||
+		len(stmt.Subject[1].Digest["xsum-sha256"]) != 64 ||
+		stmt.Subject[1].Annotations["mask"] != "0000" {
+		t.Errorf("Unexpected directory subject: %+v", stmt.Subject[1])
+	}
+}
+
+func TestRun_spdx(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"pkg/a":     "a\n",
+		"pkg/sub/b": "sub/b\n",
+	})
+	b, err := captureStdout(t, func() error {
+		return main.Run(&main.Options{
+			General: main.OptionsGeneral{Algorithm: "sha256,sha512", Format: "spdx-json"},
+			Args:    main.OptionsArgs{Paths: []string{filepath.Join(dir, "pkg")}},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Packages []struct {
+			PackageVerificationCode struct {
+				PackageVerificationCodeValue string
+			}
+			ExternalRefs []struct {
+				ReferenceLocator string
+			}
+		}
+		Files []struct {
+			FileName  string
+			Checksums []struct {
+				Algorithm     string
+				ChecksumValue string
+			}
+		}
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Packages) != 1 || len(doc.Files) != 2 {
+		t.Fatalf("Unexpected SBOM: %s", b)
+	}
+	if code := doc.Packages[0].PackageVerificationCode.PackageVerificationCodeValue; code != "821f660768781c6c10c585590bf5f1728dab1b30" {
+		t.Errorf("Unexpected verification code: %s", code)
+	}
+	if ref := doc.Packages[0].ExternalRefs[0].ReferenceLocator; !strings.HasPrefix(ref, "sha256:") || !strings.HasSuffix(ref, ":0000") {
+		t.Errorf("Unexpected external reference: %s", ref)
+	}
+	if f := doc.Files[1]; f.FileName != "./sub/b" || len(f.Checksums) != 3 ||
+		f.Checksums[0].Algorithm != "SHA1" || f.Checksums[1].Algorithm != "SHA256" || f.Checksums[2].Algorithm != "SHA512" {
+		t.Errorf("Unexpected file: %+v", f)
+	}
+}
+
+func TestBag(t *testing.T) {
+	discardStdout(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a":         "test\n",
+		"sub/b%\nc": "test\n",
+	})
+	// symlinks in the payload are followed
+	if err := os.Symlink(filepath.Join("sub", "b%\nc"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(dir, "linkdir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := main.CreateBag(&main.BagCreateOptions{
+		Algorithm: "sha256,sha512",
+		Args:      main.DirArgs{Dir: dir},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "manifest-sha256.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"data/sub/b%25%0Ac", "data/link", "data/linkdir/b%25%0Ac"} {
+		if !bytes.Contains(b, []byte("  "+path+"\n")) {
+			t.Errorf("Expected %s in manifest:\n%s", path, b)
+		}
+	}
+	b, err = os.ReadFile(filepath.Join(dir, "bag-info.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("Payload-Oxum: 20.4\n")) {
+		t.Errorf("Unexpected bag-info.txt:\n%s", b)
+	}
+
+	validate := &main.BagValidateOptions{Args: main.DirArgs{Dir: dir}}
+	if err := main.ValidateBag(validate); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data", "extra"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := main.ValidateBag(validate); err == nil || !strings.Contains(err.Error(), "1 payload file not in manifest") {
+		t.Errorf("Unexpected error for extra file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "data", "extra")); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "data", "broken")
+	if err := os.Symlink("missing", broken); err != nil {
+		t.Fatal(err)
+	}
+	if err := main.ValidateBag(validate); err == nil || !strings.Contains(err.Error(), broken) {
+		t.Errorf("Unexpected error for broken symlink: %v", err)
+	}
+	if err := os.Remove(broken); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data", "a"), []byte("TEST\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := main.ValidateBag(validate); err == nil || !strings.Contains(err.Error(), "2 computed checksums did NOT match") {
+		t.Errorf("Unexpected error for modified file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "data", "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := main.ValidateBag(validate); err == nil || !strings.Contains(err.Error(), "1 listed file missing") {
+		t.Errorf("Unexpected error for missing file: %v", err)
+	}
+}
+
+func TestMtree(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeFiles(t, root, map[string]string{
+		"a":         "test\n",
+		"sub dir/b": "test\n",
+	})
+	for name, mode := range map[string]os.FileMode{
+		"":                            0755,
+		"sub dir":                     0755,
+		"a":                           0644,
+		filepath.Join("sub dir", "b"): 0644,
+	} {
+		if err := os.Chmod(filepath.Join(root, name), mode); err != nil { // ignore umask
+			t.Fatal(err)
+		}
+	}
+	b, err := captureStdout(t, func() error {
+		return main.RunMtree(&main.MtreeOptions{
+			Algorithm: "sha256",
+			Mask:      main.OptionsMask{Mask: "0777"},
+			Args:      main.DirArgs{Dir: root},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("\n./sub\\040dir/b type=file mode=0644 size=5 sha256digest=f2ca1bb6c7e907d06dafe4687e579fce76b37e4e93b7605022da52e6ccc26fd2\n")) {
+		t.Errorf("Unexpected spec:\n%s", b)
+	}
+	spec := filepath.Join(dir, "spec")
+	if err := os.WriteFile(spec, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	discardStdout(t)
+	verify := &main.MtreeOptions{
+		Verify: spec,
+		Mask:   main.OptionsMask{Mask: "0777"},
+		Args:   main.DirArgs{Dir: root},
+	}
+	if err := main.RunMtree(verify); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(root, "a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := main.RunMtree(verify); err == nil || !strings.Contains(err.Error(), "1 path did NOT match") {
+		t.Errorf("Unexpected error for modified mode: %v", err)
+	}
+
+	hierarchical := filepath.Join(dir, "hierarchical")
+	if err := os.WriteFile(hierarchical, []byte(`#mtree
+/set type=file mode=0644
+. type=dir mode=0755
+    a mode=0600 \
+        sha256digest=f2ca1bb6c7e907d06dafe4687e579fce76b37e4e93b7605022da52e6ccc26fd2
+sub\040dir type=dir mode=0755
+    b size=5
+..
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	verify.Verify = hierarchical
+	if err := main.RunMtree(verify); err != nil {
+		t.Errorf("Unexpected error for hierarchical spec: %s", err)
+	}
+}
+
+func TestSubcommand(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	for _, arg := range []string{"bag", "mtree"} {
+		if cmd := main.Subcommand(arg); cmd != arg {
+			t.Errorf("Expected subcommand %s, got `%s'", arg, cmd)
+		}
+	}
+	if cmd := main.Subcommand("other"); cmd != "" {
+		t.Errorf("Expected no subcommand, got %s", cmd)
+	}
+	writeFiles(t, ".", map[string]string{"bag": "test\n"})
+	if cmd := main.Subcommand("bag"); cmd != "" {
+		t.Errorf("Expected file to take precedence, got %s", cmd)
+	}
+}
+
+func TestRun_sidecars(t *testing.T) {
+	discardStdout(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"cd1/01 track.flac": "one\n",
+		"cd1/cd1.sfv":       "; comment\r\n01 track.flac F817A89F\r\n",
+		"cd2/02.flac":       "two\n",
+		"cd2/cd2.md5":       "c193497a1a06b2c72230e6146ff47080 *02.flac\n",
+	})
+	check := &main.Options{
+		General: main.OptionsGeneral{Algorithm: "sha256", Check: true},
+		Args:    main.OptionsArgs{Paths: []string{dir}},
+	}
+	if err := main.Run(check); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{"cd2/02.flac": "three\n"})
+	if err := main.Run(check); err == nil || !strings.Contains(err.Error(), "1 computed checksum did NOT match") {
+		t.Errorf("Unexpected error for modified file: %v", err)
+	}
+
+	writeFiles(t, dir, map[string]string{"typed/a": "a"})
+	if err := main.Run(&main.Options{
+		General: main.OptionsGeneral{Algorithm: "md5", Write: "md5"},
+		Mask:    main.OptionsMask{Mask: "0000"},
+		Args:    main.OptionsArgs{Paths: []string{filepath.Join(dir, "typed", "a")}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "typed", "a.md5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("md5:")) || !bytes.HasSuffix(b, []byte("  a\n")) {
+		t.Errorf("Unexpected sidecar: %s", b)
+	}
+	for _, path := range []string{filepath.Join(dir, "typed", "a.md5"), filepath.Join(dir, "typed")} {
+		check.Args.Paths = []string{path}
+		if err := main.Run(check); err != nil {
+			t.Errorf("Unexpected error for typed entry in %s: %s", path, err)
+		}
+	}
+	writeFiles(t, dir, map[string]string{"typed/a": "b"})
+	if err := main.Run(check); err == nil || !strings.Contains(err.Error(), "1 computed checksum did NOT match") {
+		t.Errorf("Unexpected error for modified typed entry: %v", err)
+	}
+}
+
+func TestRun_relative(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "a", "sub/b": "sub/b"})
+	b, err := captureStdout(t, func() error {
+		return main.Run(&main.Options{
+			General: main.OptionsGeneral{Algorithm: "sha256", Root: dir},
+			Args:    main.OptionsArgs{Paths: []string{filepath.Join(dir, "a"), filepath.Join(dir, "sub", "b")}},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("  a\n")) || !bytes.HasSuffix(b, []byte("  sub/b\n")) {
+		t.Errorf("Unexpected manifest:\n%s", b)
+	}
+	manifest := filepath.Join(dir, "SHA256SUMS")
+	if err := os.WriteFile(manifest, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	discardStdout(t)
+	if err := main.Run(&main.Options{
+		General: main.OptionsGeneral{Algorithm: "sha256", Check: true, Relative: true},
+		Args:    main.OptionsArgs{Paths: []string{manifest}},
+	}); err != nil {
+		t.Error(err)
+	}
+
+	if err := main.Run(&main.Options{
+		General: main.OptionsGeneral{Algorithm: "sha256", Write: "sha256"},
+		Args:    main.OptionsArgs{Paths: []string{filepath.Join(dir, "sub", "b")}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b, err = os.ReadFile(filepath.Join(dir, "sub", "b.sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(b, []byte("  b\n")) {
+		t.Errorf("Unexpected sidecar: %s", b)
+	}
+	if err := main.Run(&main.Options{
+		General: main.OptionsGeneral{Algorithm: "sha256", Check: true},
+		Args:    main.OptionsArgs{Paths: []string{filepath.Join(dir, "sub", "b.sha256")}},
+	}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRun_recursive(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"b": "b", "sub/a": "sub/a"})
+	if err := os.Symlink("..", filepath.Join(dir, "sub", "up")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := captureStdout(t, func() error {
+		return main.Run(&main.Options{
+			General: main.OptionsGeneral{Algorithm: "sha256", Recursive: true, Root: dir},
+			Args:    main.OptionsArgs{Paths: []string{dir}},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sumB := sha256.Sum256([]byte("b"))
+	sumA := sha256.Sum256([]byte("sub/a"))
+	expected := hex.EncodeToString(sumB[:]) + "  b\n" +
+		hex.EncodeToString(sumA[:]) + "  sub/a\n"
+	if string(b) != expected {
+		t.Errorf("Unexpected manifest:\n%s", b)
+	}
+}
+
+func TestRun_filesFrom(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"b", "a", "with\nnewline", `back\slash`} {
+		writeFiles(t, dir, map[string]string{name: name})
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	list := filepath.Join(t.TempDir(), "list")
+	if err := os.WriteFile(list, []byte(strings.Join(paths, "\x00")+"\x00"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	b, err := captureStdout(t, func() error {
+		return main.Run(&main.Options{
+			General: main.OptionsGeneral{Algorithm: "sha256", Files0From: list, Root: dir},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected string
+	for _, e := range []struct{ name, entry string }{
+		{"b", "  b"},
+		{"a", "  a"},
+		{"with\nnewline", `  with\nnewline`}, // escaped like coreutils
+		{`back\slash`, `  back\\slash`},
+	} {
+		sum := sha256.Sum256([]byte(e.name))
+		if e.name != e.entry[2:] {
+			expected += `\`
+		}
+		expected += hex.EncodeToString(sum[:]) + e.entry + "\n"
+	}
+	if string(b) != expected {
+		t.Errorf("Unexpected manifest:\n%s", b)
+	}
+	manifest := filepath.Join(dir, "SHA256SUMS")
+	if err := os.WriteFile(manifest, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := main.Run(&main.Options{
+		General: main.OptionsGeneral{Algorithm: "sha256", Check: true, Relative: true, Strict: true, Quiet: true},
+		Args:    main.OptionsArgs{Paths: []string{manifest}},
+	}); err != nil {
+		t.Errorf("Failed to validate escaped paths: %s", err)
+	}
+
+	if err := main.Run(&main.Options{
+		General: main.OptionsGeneral{Algorithm: "sha256", FilesFrom: list, Files0From: list},
+	}); err == nil {
+		t.Error("Expected error for --files-from with --files0-from")
+	}
+}
+
+func TestRun_update(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "a", "b": "b", "c": "c"})
+	sumOf := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	manifest := filepath.Join(dir, "SHA256SUMS")
+	// entries without recorded state are always rehashed
+	if err := os.WriteFile(manifest, []byte(
+		sumOf("stale")+"  a\n"+
+			"# comment\n"+
+			"sha256:"+sumOf("b")+"  b\n"+
+			sumOf("c")+"  c\n",
+	), 0600); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(manifest, past, past); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{"b": "new", "d": "d"})
+	if err := os.Remove(filepath.Join(dir, "c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := main.Run(&main.Options{
+		General: main.OptionsGeneral{Algorithm: "sha256", Update: manifest, Relative: true},
+		Args:    main.OptionsArgs{Paths: []string{filepath.Join(dir, "a"), filepath.Join(dir, "d")}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := sumOf("a") + "  a\n" +
+		"# comment\n" +
+		"sha256:" + sumOf("new") + "  b\n" +
+		sumOf("d") + "  d\n"
+	if string(b) != expected {
+		t.Errorf("Unexpected manifest:\n%s", b)
+	}
+	fi, err := os.Stat(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Errorf("Unexpected mode: %s", fi.Mode())
+	}
+
+	// recently modified files are not recorded
+	update := func() string {
+		t.Helper()
+		if err := main.Run(&main.Options{
+			General: main.OptionsGeneral{Algorithm: "sha256", Update: manifest, Relative: true},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	state := manifest + ".xsum-state"
+	for _, name := range []string{"a", "b", "d"} {
+		if err := os.Chtimes(filepath.Join(dir, name), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out := update(); out != expected {
+		t.Errorf("Unexpected manifest:\n%s", out)
+	}
+	b, err = os.ReadFile(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "#xsum-state 1 sha256:") || !strings.HasPrefix(lines[1], `"a" `) {
+		t.Errorf("Expected header and 3 relative entries, got:\n%s", b)
+	}
+
+	// manifests edited by hand are fully rehashed
+	replaceSum := func(path, old, new string) {
+		t.Helper()
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, bytes.ReplaceAll(b, []byte(old), []byte(new)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	replaceSum(manifest, sumOf("a"), sumOf("x"))
+	if out := update(); out != expected {
+		t.Errorf("Unexpected manifest:\n%s", out)
+	}
+
+	// entries with matching recorded state are not rehashed, even if the directory is moved
+	moved := filepath.Join(t.TempDir(), "moved")
+	if err := os.Rename(dir, moved); err != nil {
+		t.Fatal(err)
+	}
+	dir, manifest, state = moved, filepath.Join(moved, "SHA256SUMS"), filepath.Join(moved, "SHA256SUMS.xsum-state")
+	replaceSum(manifest, sumOf("a"), sumOf("x"))
+	replaceSum(state, sumOf("a"), sumOf("x"))
+	b, err = os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestSum := sha256.Sum256(b)
+	replaceSum(state, lines[0], "#xsum-state 1 sha256:"+hex.EncodeToString(manifestSum[:]))
+	expected = strings.Replace(expected, sumOf("a"), sumOf("x"), 1)
+	if out := update(); out != expected {
+		t.Errorf("Unexpected manifest:\n%s", out)
+	}
+
+	// changes are detected even if the manifest is newer than the file
+	writeFiles(t, dir, map[string]string{"b": "NEW"})
+	older := past.Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "b"), older, older); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := os.Chtimes(manifest, now, now); err != nil {
+		t.Fatal(err)
+	}
+	expected = strings.Replace(expected, sumOf("new"), sumOf("NEW"), 1)
+	if out := update(); out != expected {
+		t.Errorf("Unexpected manifest:\n%s", out)
+	}
+}
+
+func TestRun_writeMode(t *testing.T) {
+	discardStdout(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "old", "sub/b": "old"})
+	paths := []string{filepath.Join(dir, "a"), filepath.Join(dir, "sub", "b")}
+	write := func(mode string, combined bool) error {
+		return main.Run(&main.Options{
+			General: main.OptionsGeneral{Algorithm: "sha256", Write: "sha256", WriteMode: mode, Combined: combined},
+			Args:    main.OptionsArgs{Paths: paths},
+		})
+	}
+	if err := write("", false); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(paths[0] + ".sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&^0644 != 0 {
+		t.Errorf("Unexpected mode: %s", fi.Mode())
+	}
+	if err := write("verify", false); err != nil {
+		t.Error(err)
+	}
+	writeFiles(t, dir, map[string]string{"a": "new"})
+	if err := write("skip", false); err != nil {
+		t.Error(err)
+	}
+	if err := write("verify", false); err == nil {
+		t.Error("Expected verification failure for skipped sidecar")
+	}
+	for _, level := range []struct {
+		quiet, status bool
+		out           string
+	}{
+		{false, false, paths[0] + ": FAILED\n" + paths[1] + ": OK\n"},
+		{true, false, paths[0] + ": FAILED\n"},
+		{false, true, ""},
+	} {
+		out, err := captureStdout(t, func() error {
+			return main.Run(&main.Options{
+				General: main.OptionsGeneral{Algorithm: "sha256", Write: "sha256", WriteMode: "verify", Quiet: level.quiet, Status: level.status},
+				Args:    main.OptionsArgs{Paths: paths},
+			})
+		})
+		if cErr, ok := err.(*main.CheckError); !ok || cErr.Code != main.ExitFailed || (len(cErr.Warnings) == 0) != level.status {
+			t.Errorf("Unexpected error with quiet=%t, status=%t: %v", level.quiet, level.status, err)
+		}
+		if string(out) != level.out {
+			t.Errorf("Unexpected output with quiet=%t, status=%t:\n%s", level.quiet, level.status, out)
+		}
+	}
+	if err := write("overwrite", false); err != nil {
+		t.Error(err)
+	}
+	if err := write("verify", false); err != nil {
+		t.Error(err)
+	}
+	// new files are created with the same mode in every write mode
+	if err := os.Remove(paths[1] + ".sha256"); err != nil {
+		t.Fatal(err)
+	}
+	if err := write("overwrite", false); err != nil {
+		t.Error(err)
+	}
+	if fi2, err := os.Stat(paths[1] + ".sha256"); err != nil || fi2.Mode() != fi.Mode() {
+		t.Errorf("Unexpected mode for new file: %v, %v", fi2, err)
+	}
+
+	if err := write("", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		b, err := os.ReadFile(filepath.Join(filepath.Dir(path), "SHA256SUMS"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasSuffix(b, []byte("  "+filepath.Base(path)+"\n")) {
+			t.Errorf("Unexpected manifest: %s", b)
+		}
+	}
+	if err := write("verify", true); err != nil {
+		t.Error(err)
+	}
+	if err := write("bad", false); err == nil {
+		t.Error("Expected error for invalid write mode")
+	}
+}
+
+func TestRun_checkSummary(t *testing.T) {
+	discardStdout(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "a"})
+	sum := sha256.Sum256([]byte("a"))
+	check := func(entries string, strict, ignore bool) int {
+		manifest := filepath.Join(dir, "SHA256SUMS")
+		if err := os.WriteFile(manifest, []byte(entries), 0666); err != nil {
+			t.Fatal(err)
+		}
+		err := main.Run(&main.Options{
+			General: main.OptionsGeneral{Algorithm: "sha256", Check: true, Relative: true, Strict: strict, IgnoreMissing: ignore},
+			Args:    main.OptionsArgs{Paths: []string{manifest}},
+		})
+		if err == nil {
+			return 0
+		}
+		cErr, ok := err.(*main.CheckError)
+		if !ok {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		return cErr.Code
+	}
+	ok := hex.EncodeToString(sum[:]) + "  a\n"
+	bad := strings.Repeat("0", 64) + "  a\n"
+	missing := hex.EncodeToString(sum[:]) + "  missing\n"
+	for _, tc := range []struct {
+		entries        string
+		strict, ignore bool
+		code           int
+	}{
+		{ok, false, false, 0},
+		{ok + "malformed\n", false, false, 0},
+		{ok + "malformed\n", true, false, main.ExitMalformed},
+		{ok + missing, false, false, main.ExitIO},
+		{ok + missing, false, true, 0},
+		{bad + missing + "malformed\n", true, false, main.ExitFailed},
+	} {
+		if code := check(tc.entries, tc.strict, tc.ignore); code != tc.code {
+			t.Errorf("Unexpected exit code %d for:\n%s", code, tc.entries)
+		}
+	}
+}
+
+func TestRun_pluginWarnings(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin scripts require sh")
+	}
+	bin := t.TempDir()
+	plugin := "#!/bin/sh\n" +
+		"[ \"$1\" = --xsum-info ] && exit 1\n" +
+		"[ \"$XSUM_PLUGIN_TYPE\" = data ] && echo 'warning: lossy: assuming lossy' >&2\n" +
+		"printf abcd\n"
+	if err := os.WriteFile(filepath.Join(bin, "xsum-warn"), []byte(plugin), 0777); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "a", "sub/b": "b"})
+	manifest := filepath.Join(dir, "SUMS")
+	if err := os.WriteFile(manifest, []byte("abcd  a\nabcd  sub\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	b, err := captureStdout(t, func() error {
+		return main.Run(&main.Options{
+			General: main.OptionsGeneral{Algorithm: "warn", Check: true, Relative: true, Quiet: true},
+			Args:    main.OptionsArgs{Paths: []string{manifest}},
+		})
+	})
+	if err != nil {
+		t.Fatalf("Expected warnings to pass, got: %s", err)
+	}
+	// shown with -q, including for directories that contain files with warnings
+	expected := filepath.Join(dir, "a") + ": WARNED (lossy)\n" +
+		filepath.Join(dir, "sub") + ": WARNED (lossy)\n"
+	if string(b) != expected {
+		t.Errorf("Unexpected output:\n%s", b)
+	}
+}
+
+func writeCommented(t *testing.T, dir, name string, b []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	content := "untrusted comment: test\n" + base64.StdEncoding.EncodeToString(b) + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func bl(v ...interface{}) []byte {
+	var out []byte
+	for _, b := range v {
+		switch b := b.(type) {
+		case []byte:
+			out = append(out, b...)
+		case ed25519.PublicKey:
+			out = append(out, b...)
+		case ed25519.PrivateKey:
+			out = append(out, b...)
+		case string:
+			out = append(out, b...)
+		}
+	}
+	return out
+}
+
+// captureStdout runs fn with os.Stdout redirected to a temporary file, and returns the output of fn.
+func captureStdout(t *testing.T, fn func() error) ([]byte, error) {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	defer func(stdout *os.File) {
+		os.Stdout = stdout
+	}(os.Stdout)
+	os.Stdout = out
+	runErr := fn()
+	b, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, runErr
+}
+
+// discardStdout redirects os.Stdout to os.DevNull until the test completes.
+func discardStdout(t *testing.T) {
+	t.Helper()
+	null, err := os.Create(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = null
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		null.Close()
+	})
+}
+
+// writeFiles creates each file (a slash-separated path relative to dir) with the provided content, including parent directories.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
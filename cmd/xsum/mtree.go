@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/cli"
+)
+
+// See https://man.freebsd.org/cgi/man.cgi?query=mtree&sektion=5
+
+type MtreeOptions struct {
+	Algorithm string      `short:"a" long:"algorithm" default:"sha256" description:"Use specified hash function for digests\nOne of md5, sha1, sha256, sha384, sha512, or rmd160"`
+	Verify    string      `long:"verify" description:"Compare directory to mtree spec file (- for stdin)"`
+	Quiet     bool        `short:"q" long:"quiet" description:"With --verify, suppress passing paths"`
+	Mask      OptionsMask `group:"Mask Options (default: -m 7777+ugt)"`
+	Args      DirArgs     `positional-args:"yes" required:"yes"`
+}
+
+func mainMtree(args []string) {
+	var opts MtreeOptions
+	parseCommand("xsum mtree", &opts, args)
+	if err := RunMtree(&opts); err != nil {
+		if iErr, ok := err.(*InitError); ok {
+			log.Fatal(iErr)
+		}
+		log.Fatalf("xsum: %s", err)
+	}
+}
+
+// mtreeDigests maps mtree digest keywords to xsum algorithms
+var mtreeDigests = map[string]string{
+	"md5digest":       xsum.HashMD5,
+	"md5":             xsum.HashMD5,
+	"sha1digest":      xsum.HashSHA1,
+	"sha1":            xsum.HashSHA1,
+	"sha256digest":    xsum.HashSHA256,
+	"sha256":          xsum.HashSHA256,
+	"sha384digest":    xsum.HashSHA384,
+	"sha384":          xsum.HashSHA384,
+	"sha512digest":    xsum.HashSHA512,
+	"sha512":          xsum.HashSHA512,
+	"rmd160digest":    xsum.HashRMD160,
+	"rmd160":          xsum.HashRMD160,
+	"ripemd160digest": xsum.HashRMD160,
+}
+
+// RunMtree writes an mtree spec for a directory, or verifies a directory against an mtree spec.
+// Only keywords corresponding to attributes in the mask are written or verified.
+// Attributes without a corresponding keyword (e.g., +c, +x) are ignored.
+func RunMtree(opts *MtreeOptions) error {
+	if multipleTrue(
+		opts.Mask.Mask != "",
+		opts.Mask.Directory,
+		opts.Mask.Portable,
+		opts.Mask.Git,
+		opts.Mask.Full,
+		opts.Mask.Extended,
+		opts.Mask.Everything) {
+		return newInitError("Only one of -m, -p, -g, -f, -x, or -e permitted.")
+	}
+	mask, basic, err := parseMask(&opts.Mask)
+	if err != nil {
+		return err
+	}
+	if basic {
+		mask = xsum.NewMask(07777, xsum.AttrUID|xsum.AttrGID|xsum.AttrMtime)
+	}
+	if opts.Verify != "" {
+		var r io.Reader = os.Stdin
+		if opts.Verify != "-" {
+			f, err := os.Open(opts.Verify)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		entries, err := readMtree(r)
+		if err != nil {
+			return err
+		}
+		return verifyMtree(opts.Args.Dir, entries, mask, opts.Quiet)
+	}
+	keyword := strings.ToLower(opts.Algorithm) + "digest"
+	if _, ok := mtreeDigests[keyword]; !ok {
+		return newInitError(fmt.Sprintf("Algorithm `%s' not supported by mtree.", opts.Algorithm))
+	}
+	hash, err := cli.ParseHash(opts.Algorithm)
+	if err != nil {
+		return wrapInitError("Invalid algorithm:", err)
+	}
+	return writeMtree(os.Stdout, opts.Args.Dir, mask, hash)
+}
+
+// mtreeEntry is a path relative to the root of an mtree spec (e.g., "." or "a/b")
+type mtreeEntry struct {
+	path     string
+	keywords map[string]string
+}
+
+// walkMtree calls fn with the slash-separated path relative to root of each path under root in lexical order.
+// Symlinks to directories are not descended, even if followed.
+func walkMtree(root string, follow bool, fn func(rel string, fi os.FileInfo) error) error {
+	return walk(root, walkOptions{follow: follow}, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), fi)
+	})
+}
+
+// writeMtree writes a full-path mtree spec, with a digest of each regular file calculated using hash
+func writeMtree(w io.Writer, root string, mask xsum.Mask, hash xsum.Hash) error {
+	follow := mask.Attr&xsum.AttrFollow != 0
+	noData := mask.Attr&xsum.AttrNoData != 0
+	var (
+		entries []mtreeEntry
+		files   []xsum.File
+	)
+	if err := walkMtree(root, follow, func(rel string, fi os.FileInfo) error {
+		kw, err := mtreeKeywords(filepath.Join(root, filepath.FromSlash(rel)), fi, mask)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, mtreeEntry{path: rel, keywords: kw})
+		if fi.Mode().IsRegular() && !noData {
+			files = append(files, xsum.File{Hash: hash, Path: filepath.Join(root, filepath.FromSlash(rel))})
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	digests := map[string]string{}
+	sum := &xsum.Sum{}
+	if err := sum.EachList(files, func(n *xsum.Node) error {
+		if n.Err != nil {
+			return n.Err
+		}
+		digests[n.Path] = n.SumString()
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#mtree")
+	for _, e := range entries {
+		line := []string{encodeMtreePath(e.path)}
+		for _, k := range []string{"type", "mode", "uid", "gid", "time", "size", "link", "device"} {
+			if v, ok := e.keywords[k]; ok {
+				line = append(line, k+"="+v)
+			}
+		}
+		if d, ok := digests[filepath.Join(root, filepath.FromSlash(e.path))]; ok {
+			line = append(line, hash.String()+"digest="+d)
+		}
+		fmt.Fprintln(bw, strings.Join(line, " "))
+	}
+	return bw.Flush()
+}
+
+// mtreeKeywords returns the keywords for a file enabled by mask, excluding digests
+func mtreeKeywords(p string, fi os.FileInfo, mask xsum.Mask) (map[string]string, error) {
+	kw := map[string]string{"type": mtreeType(fi.Mode())}
+	if mask.Mode != 0 {
+		kw["mode"] = fmt.Sprintf("%#o", mtreeMode(fi.Mode())&uint32(mask.Mode))
+	}
+	if mask.Attr&(xsum.AttrUID|xsum.AttrGID|xsum.AttrMtime|xsum.AttrSpecial) != 0 {
+		sys, err := xsum.GetSys(fi)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		if mask.Attr&xsum.AttrUID != 0 && sys.UID != nil {
+			kw["uid"] = strconv.FormatUint(uint64(*sys.UID), 10)
+		}
+		if mask.Attr&xsum.AttrGID != 0 && sys.GID != nil {
+			kw["gid"] = strconv.FormatUint(uint64(*sys.GID), 10)
+		}
+		if mask.Attr&xsum.AttrMtime != 0 && sys.Mtime != nil {
+			kw["time"] = fmt.Sprintf("%d.%09d", sys.Mtime.Sec, sys.Mtime.Nsec)
+		}
+		if mask.Attr&xsum.AttrSpecial != 0 && sys.Rdev != nil && fi.Mode()&os.ModeDevice != 0 {
+			kw["device"] = strconv.FormatUint(*sys.Rdev, 10)
+		}
+	}
+	if mask.Attr&xsum.AttrNoData == 0 {
+		switch {
+		case fi.Mode().IsRegular():
+			kw["size"] = strconv.FormatInt(fi.Size(), 10)
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return nil, err
+			}
+			kw["link"] = encodeMtree(link)
+		}
+	}
+	return kw, nil
+}
+
+func mtreeType(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "dir"
+	case mode&os.ModeSymlink != 0:
+		return "link"
+	case mode&os.ModeCharDevice != 0:
+		return "char"
+	case mode&os.ModeDevice != 0:
+		return "block"
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	}
+	return "file"
+}
+
+func mtreeMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= sModeSetuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= sModeSetgid
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= sModeSticky
+	}
+	return m
+}
+
+const (
+	sModeSetuid = 04000
+	sModeSetgid = 02000
+	sModeSticky = 01000
+)
+
+// encodeMtreePath encodes a path relative to the root of a spec
+func encodeMtreePath(p string) string {
+	if p == "." {
+		return p
+	}
+	return "./" + encodeMtree(p)
+}
+
+// encodeMtree encodes whitespace, non-printable characters, and special characters as octal escapes
+func encodeMtree(s string) string {
+	var out strings.Builder
+	for _, b := range []byte(s) {
+		if b <= ' ' || b >= 0x7f || strings.IndexByte(`\#*?[`, b) >= 0 {
+			fmt.Fprintf(&out, `\%03o`, b)
+		} else {
+			out.WriteByte(b)
+		}
+	}
+	return out.String()
+}
+
+// decodeMtree decodes octal and C-style escapes
+func decodeMtree(p string) string {
+	var out []byte
+	for i := 0; i < len(p); i++ {
+		if p[i] != '\\' || i+1 == len(p) {
+			out = append(out, p[i])
+			continue
+		}
+		if i+3 < len(p) && isOctal(p[i+1]) && isOctal(p[i+2]) && isOctal(p[i+3]) {
+			n, _ := strconv.ParseUint(p[i+1:i+4], 8, 8)
+			out = append(out, byte(n))
+			i += 3
+			continue
+		}
+		i++
+		switch p[i] {
+		case 'n':
+			out = append(out, '\n')
+		case 't':
+			out = append(out, '\t')
+		case 'r':
+			out = append(out, '\r')
+		case 's':
+			out = append(out, ' ')
+		case '0':
+			out = append(out, 0)
+		default:
+			out = append(out, p[i])
+		}
+	}
+	return string(out)
+}
+
+func isOctal(b byte) bool {
+	return b >= '0' && b <= '7'
+}
+
+// readMtree parses an mtree spec in either full-path or hierarchical format.
+// Entries with the same path are merged.
+func readMtree(r io.Reader) ([]mtreeEntry, error) {
+	var (
+		entries []mtreeEntry
+		cont    string
+		cwd     = "."
+	)
+	index := map[string]int{}
+	defaults := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := cont + scanner.Text()
+		cont = ""
+		if strings.HasSuffix(line, `\`) && !strings.HasSuffix(line, `\\`) {
+			cont = strings.TrimSuffix(line, `\`) + " "
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch fields[0] {
+		case "/set":
+			for k, v := range parseMtreeKeywords(fields[1:]) {
+				defaults[k] = v
+			}
+			continue
+		case "/unset":
+			for _, k := range fields[1:] {
+				if k == "all" {
+					defaults = map[string]string{}
+				}
+				delete(defaults, k)
+			}
+			continue
+		case "..":
+			if cwd == "." {
+				return nil, fmt.Errorf("invalid mtree spec: `..' above root")
+			}
+			cwd = path.Dir(cwd)
+			continue
+		}
+		kw := map[string]string{}
+		for k, v := range defaults {
+			kw[k] = v
+		}
+		for k, v := range parseMtreeKeywords(fields[1:]) {
+			kw[k] = v
+		}
+		name := decodeMtree(fields[0])
+		var p string
+		if strings.Contains(name, "/") {
+			p = path.Clean(name)
+		} else {
+			p = path.Join(cwd, name)
+			if kw["type"] == "dir" && name != "." {
+				cwd = p
+			}
+		}
+		if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+			return nil, fmt.Errorf("invalid mtree spec: invalid path `%s'", name)
+		}
+		if i, ok := index[p]; ok {
+			for k, v := range kw {
+				entries[i].keywords[k] = v
+			}
+			continue
+		}
+		index[p] = len(entries)
+		entries = append(entries, mtreeEntry{path: p, keywords: kw})
+	}
+	return entries, scanner.Err()
+}
+
+func parseMtreeKeywords(fields []string) map[string]string {
+	kw := map[string]string{}
+	for _, f := range fields {
+		i := strings.IndexByte(f, '=')
+		if i < 0 {
+			kw[f] = ""
+			continue
+		}
+		kw[f[:i]] = f[i+1:]
+	}
+	return kw
+}
+
+// verifyMtree compares each path in the spec to dir, and reports paths missing from the spec
+func verifyMtree(dir string, entries []mtreeEntry, mask xsum.Mask, quiet bool) error {
+	follow := mask.Attr&xsum.AttrFollow != 0
+	var (
+		mismatches = make([][]string, len(entries))
+		missing    = make([]bool, len(entries))
+		files      []xsum.File
+		fileIdx    []int
+		expected   []string
+		keywords   []string
+	)
+	inSpec := map[string]bool{}
+	for i, e := range entries {
+		inSpec[e.path] = true
+		p := filepath.Join(dir, filepath.FromSlash(e.path))
+		var (
+			fi  os.FileInfo
+			err error
+		)
+		if follow {
+			fi, err = os.Stat(p)
+		} else {
+			fi, err = os.Lstat(p)
+		}
+		if os.IsNotExist(err) {
+			missing[i] = true
+			continue
+		} else if err != nil {
+			return err
+		}
+		actual, err := mtreeKeywords(p, fi, mask)
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"type", "mode", "uid", "gid", "time", "size", "link", "device"} {
+			exp, ok := e.keywords[k]
+			if !ok {
+				continue
+			}
+			act, ok := actual[k]
+			if !ok {
+				continue // not enabled by mask
+			}
+			if !mtreeEqual(k, exp, act, mask) {
+				mismatches[i] = append(mismatches[i], fmt.Sprintf("%s (expected %s, found %s)", k, exp, act))
+			}
+		}
+		if !fi.Mode().IsRegular() || mask.Attr&xsum.AttrNoData != 0 {
+			continue
+		}
+		for k, v := range e.keywords {
+			alg, ok := mtreeDigests[k]
+			if !ok {
+				continue
+			}
+			hash, err := cli.ParseHash(alg)
+			if err != nil {
+				return err
+			}
+			files = append(files, xsum.File{Hash: hash, Path: p})
+			fileIdx = append(fileIdx, i)
+			expected = append(expected, strings.ToLower(v))
+			keywords = append(keywords, k)
+		}
+	}
+
+	var j int
+	sum := &xsum.Sum{}
+	if err := sum.EachList(files, func(n *xsum.Node) error {
+		i := fileIdx[j]
+		if n.Err != nil {
+			log.Printf("xsum: %s", n.Err)
+			mismatches[i] = append(mismatches[i], keywords[j]+" FAILED")
+		} else if act := hex.EncodeToString(n.Sum); act != expected[j] {
+			mismatches[i] = append(mismatches[i], fmt.Sprintf("%s (expected %s, found %s)", keywords[j], expected[j], act))
+		}
+		j++
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var nMissing, nMismatched, nExtra int
+	for i, e := range entries {
+		name := encodeMtreePath(e.path)
+		switch {
+		case missing[i]:
+			fmt.Println(name + ": MISSING")
+			nMissing++
+		case len(mismatches[i]) > 0:
+			for _, m := range mismatches[i] {
+				fmt.Println(name + ": " + m)
+			}
+			nMismatched++
+		case !quiet:
+			fmt.Println(name + ": OK")
+		}
+	}
+	if err := walkMtree(dir, follow, func(rel string, _ os.FileInfo) error {
+		if !inSpec[rel] {
+			fmt.Println(encodeMtreePath(rel) + ": EXTRA")
+			nExtra++
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var problems []string
+	if nMissing > 0 {
+		problems = append(problems, fmt.Sprintf("%d path%s missing", nMissing, plural(nMissing)))
+	}
+	if nExtra > 0 {
+		problems = append(problems, fmt.Sprintf("%d path%s not in spec", nExtra, plural(nExtra)))
+	}
+	if nMismatched > 0 {
+		problems = append(problems, fmt.Sprintf("%d path%s did NOT match", nMismatched, plural(nMismatched)))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("WARNING: directory does NOT match mtree spec: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// mtreeEqual compares an expected keyword value from a spec to an actual value
+func mtreeEqual(keyword, exp, act string, mask xsum.Mask) bool {
+	switch keyword {
+	case "mode":
+		e, err1 := strconv.ParseUint(exp, 8, 32)
+		a, err2 := strconv.ParseUint(act, 8, 32)
+		return err1 == nil && err2 == nil && e&uint64(mask.Mode) == a
+	case "time":
+		return parseMtreeTime(exp) == parseMtreeTime(act)
+	case "link":
+		return decodeMtree(exp) == decodeMtree(act)
+	case "uid", "gid", "size", "device":
+		e, err1 := strconv.ParseUint(exp, 10, 64)
+		a, err2 := strconv.ParseUint(act, 10, 64)
+		return err1 == nil && err2 == nil && e == a
+	}
+	return exp == act
+}
+
+// parseMtreeTime parses seconds and nanoseconds, returning an empty value if invalid
+func parseMtreeTime(t string) [2]int64 {
+	var ts [2]int64
+	parts := strings.SplitN(t, ".", 2)
+	var err error
+	if ts[0], err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return [2]int64{-1, -1}
+	}
+	if len(parts) == 2 {
+		if ts[1], err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return [2]int64{-1, -1}
+		}
+	}
+	return ts
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/cli"
+)
+
+const (
+	formatSPDX      = "spdx-json"
+	formatCycloneDX = "cyclonedx-json"
+)
+
+// See https://spdx.github.io/spdx-spec/v2.3/file-information/#710-file-checksum-field
+var spdxAlgorithms = map[string]string{
+	xsum.HashMD4:        "MD4",
+	xsum.HashMD5:        "MD5",
+	xsum.HashSHA1:       "SHA1",
+	xsum.HashSHA224:     "SHA224",
+	xsum.HashSHA256:     "SHA256",
+	xsum.HashSHA384:     "SHA384",
+	xsum.HashSHA512:     "SHA512",
+	xsum.HashSHA3_256:   "SHA3-256",
+	xsum.HashSHA3_384:   "SHA3-384",
+	xsum.HashSHA3_512:   "SHA3-512",
+	xsum.HashBlake2b256: "BLAKE2b-256",
+	xsum.HashBlake2b384: "BLAKE2b-384",
+	xsum.HashBlake2b512: "BLAKE2b-512",
+	xsum.HashAdler32:    "ADLER32",
+}
+
+// See https://cyclonedx.org/docs/1.5/json/#components_items_hashes_items_alg
+var cycloneDXAlgorithms = map[string]string{
+	xsum.HashMD5:        "MD5",
+	xsum.HashSHA1:       "SHA-1",
+	xsum.HashSHA256:     "SHA-256",
+	xsum.HashSHA384:     "SHA-384",
+	xsum.HashSHA512:     "SHA-512",
+	xsum.HashSHA3_256:   "SHA3-256",
+	xsum.HashSHA3_384:   "SHA3-384",
+	xsum.HashSHA3_512:   "SHA3-512",
+	xsum.HashBlake2b256: "BLAKE2b-256",
+	xsum.HashBlake2b384: "BLAKE2b-384",
+	xsum.HashBlake2b512: "BLAKE2b-512",
+}
+
+// sbomPackage is a path passed on the command line
+type sbomPackage struct {
+	name  string
+	sum   *xsum.Node // xsum checksum of the entire path
+	files []sbomFile
+}
+
+// sbomFile is a regular file within an sbomPackage
+type sbomFile struct {
+	name string            // relative to package, e.g., ./dir/file
+	sums map[string]string // indexed by xsum hash name
+}
+
+// checkSBOMHashes returns an error if any hash cannot be represented in the SBOM format.
+func checkSBOMHashes(format string, hashes []xsum.Hash) error {
+	algs := spdxAlgorithms
+	if format == formatCycloneDX {
+		algs = cycloneDXAlgorithms
+	}
+	for _, h := range hashes {
+		if _, ok := algs[h.String()]; !ok {
+			return fmt.Errorf("algorithm `%s' not supported by %s", h, format)
+		}
+	}
+	return nil
+}
+
+// collectSBOM walks each path and calculates per-file checksums for each hash.
+// Each path is also checksummed using pathHash and the provided mask.
+// Unlike other output modes, any error aborts SBOM generation, so that an incomplete file set is never reported.
+func collectSBOM(paths []string, mask xsum.Mask, hashes []xsum.Hash, pathHash xsum.Hash) ([]sbomPackage, error) {
+	var (
+		pkgs  []sbomPackage
+		files []xsum.File
+	)
+	for _, path := range paths {
+		if path == "-" {
+			return nil, fmt.Errorf("standard input not supported")
+		}
+		pkg := sbomPackage{name: filepath.ToSlash(path)}
+		fpaths, err := walkFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, fpath := range fpaths {
+			rel, err := filepath.Rel(path, fpath)
+			if err != nil {
+				return nil, err
+			}
+			if rel == "." {
+				rel = filepath.Base(fpath)
+			}
+			pkg.files = append(pkg.files, sbomFile{
+				name: "./" + filepath.ToSlash(rel),
+				sums: map[string]string{},
+			})
+			for _, h := range hashes {
+				files = append(files, xsum.File{Hash: h, Path: fpath})
+			}
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	sum := &xsum.Sum{}
+	var p, f, i int
+	if err := sum.EachList(files, func(n *xsum.Node) error {
+		if n.Err != nil {
+			return n.Err
+		}
+		for f == len(pkgs[p].files) {
+			p, f = p+1, 0
+		}
+		pkgs[p].files[f].sums[n.Hash.String()] = n.SumString()
+		if i++; i == len(hashes) {
+			f, i = f+1, 0
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	p = 0
+	if err := sum.EachList(convertToFiles(paths, mask, pathHash), func(n *xsum.Node) error {
+		if n.Err != nil {
+			return n.Err
+		}
+		pkgs[p].sum = n
+		p++
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return pkgs, nil
+}
+
+// spdxVerificationCode returns the SPDX package verification code for a set of files.
+// See https://spdx.github.io/spdx-spec/v2.3/package-information/#79-package-verification-code-field
+func spdxVerificationCode(files []sbomFile) string {
+	var sums []string
+	for _, f := range files {
+		sums = append(sums, f.sums[xsum.HashSHA1])
+	}
+	sort.Strings(sums)
+	code := sha1.Sum([]byte(strings.Join(sums, "")))
+	return hex.EncodeToString(code[:])
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Files             []spdxFile         `json:"files"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name                    string                  `json:"name"`
+	SPDXID                  string                  `json:"SPDXID"`
+	DownloadLocation        string                  `json:"downloadLocation"`
+	FilesAnalyzed           bool                    `json:"filesAnalyzed"`
+	PackageVerificationCode spdxVerificationCodeObj `json:"packageVerificationCode"`
+	ExternalRefs            []spdxExternalRef       `json:"externalRefs"`
+}
+
+type spdxVerificationCodeObj struct {
+	Value string `json:"packageVerificationCodeValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxFile struct {
+	FileName  string         `json:"fileName"`
+	SPDXID    string         `json:"SPDXID"`
+	Checksums []spdxChecksum `json:"checksums"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// newSPDXDocument returns an SPDX 2.3 document with a package for each path.
+// SHA1 checksums must be present for each file.
+func newSPDXDocument(pkgs []sbomPackage, hashes []xsum.Hash, format outputFormat) (*spdxDocument, error) {
+	id, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	name := "xsum"
+	if len(pkgs) == 1 {
+		name = pkgs[0].name
+	}
+	doc := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: "https://spdx.org/spdxdocs/xsum-" + id,
+		CreationInfo: spdxCreationInfo{
+			Created:  time.Now().UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: xsum-" + Version},
+		},
+		Packages:      []spdxPackage{},
+		Files:         []spdxFile{},
+		Relationships: []spdxRelationship{},
+	}
+	for i, pkg := range pkgs {
+		pkgID := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		doc.Packages = append(doc.Packages, spdxPackage{
+			Name:             pkg.name,
+			SPDXID:           pkgID,
+			DownloadLocation: "NOASSERTION",
+			FilesAnalyzed:    true,
+			PackageVerificationCode: spdxVerificationCodeObj{
+				Value: spdxVerificationCode(pkg.files),
+			},
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "OTHER",
+				ReferenceType:     "xsum",
+				ReferenceLocator:  formatSBOMChecksum(pkg.sum, format),
+			}},
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      doc.SPDXID,
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: pkgID,
+		})
+		for _, f := range pkg.files {
+			fileID := fmt.Sprintf("SPDXRef-File-%d", len(doc.Files)+1)
+			file := spdxFile{FileName: f.name, SPDXID: fileID}
+			for _, h := range hashes {
+				file.Checksums = append(file.Checksums, spdxChecksum{
+					Algorithm:     spdxAlgorithms[h.String()],
+					ChecksumValue: f.sums[h.String()],
+				})
+			}
+			doc.Files = append(doc.Files, file)
+			doc.Relationships = append(doc.Relationships, spdxRelationship{
+				SPDXElementID:      pkgID,
+				RelationshipType:   "CONTAINS",
+				RelatedSPDXElement: fileID,
+			})
+		}
+	}
+	return doc, nil
+}
+
+type cycloneDXBOM struct {
+	BOMFormat    string               `json:"bomFormat"`
+	SpecVersion  string               `json:"specVersion"`
+	SerialNumber string               `json:"serialNumber"`
+	Version      int                  `json:"version"`
+	Metadata     cycloneDXMetadata    `json:"metadata"`
+	Components   []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string                 `json:"timestamp"`
+	Tools     cycloneDXMetadataTools `json:"tools"`
+}
+
+type cycloneDXMetadataTools struct {
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXComponent struct {
+	Type               string                 `json:"type"`
+	BOMRef             string                 `json:"bom-ref,omitempty"`
+	Name               string                 `json:"name"`
+	Version            string                 `json:"version,omitempty"`
+	Hashes             []cycloneDXHash        `json:"hashes,omitempty"`
+	ExternalReferences []cycloneDXExternalRef `json:"externalReferences,omitempty"`
+	Components         []cycloneDXComponent   `json:"components,omitempty"`
+}
+
+type cycloneDXHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cycloneDXExternalRef struct {
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// newCycloneDXBOM returns a CycloneDX 1.5 BOM with a component for each path.
+// Each file is a nested component of the component for its path.
+func newCycloneDXBOM(pkgs []sbomPackage, hashes []xsum.Hash, format outputFormat) (*cycloneDXBOM, error) {
+	id, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	bom := &cycloneDXBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + id,
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Tools: cycloneDXMetadataTools{
+				Components: []cycloneDXComponent{{Type: "application", Name: "xsum", Version: Version}},
+			},
+		},
+		Components: []cycloneDXComponent{},
+	}
+	files := 0
+	for i, pkg := range pkgs {
+		comp := cycloneDXComponent{
+			Type:   "library",
+			BOMRef: fmt.Sprintf("package-%d", i+1),
+			Name:   pkg.name,
+			ExternalReferences: []cycloneDXExternalRef{{
+				Type:    "other",
+				URL:     formatSBOMChecksum(pkg.sum, format),
+				Comment: "xsum",
+			}},
+		}
+		for _, f := range pkg.files {
+			files++
+			file := cycloneDXComponent{
+				Type:   "file",
+				BOMRef: fmt.Sprintf("file-%d", files),
+				Name:   f.name,
+			}
+			for _, h := range hashes {
+				file.Hashes = append(file.Hashes, cycloneDXHash{
+					Alg:     cycloneDXAlgorithms[h.String()],
+					Content: f.sums[h.String()],
+				})
+			}
+			comp.Components = append(comp.Components, file)
+		}
+		bom.Components = append(bom.Components, comp)
+	}
+	return bom, nil
+}
+
+// formatSBOMChecksum returns an xsum checksum that may be validated with xsum -c
+func formatSBOMChecksum(n *xsum.Node, format outputFormat) string {
+	if format.opaque {
+		return n.Hex()
+	}
+	return n.String()
+}
+
+// outputSBOM writes an SPDX or CycloneDX document with a package for each path.
+// The xsum checksum of each path is calculated using the first hash.
+// For SPDX, SHA1 checksums are always included, because they are required for each file.
+func outputSBOM(paths []string, mask xsum.Mask, hashes []xsum.Hash, format outputFormat, sbomFormat string) error {
+	fileHashes := hashes
+	if sbomFormat == formatSPDX && !hasHash(hashes, xsum.HashSHA1) {
+		h, err := cli.ParseHash(xsum.HashSHA1)
+		if err != nil {
+			return err
+		}
+		fileHashes = append([]xsum.Hash{h}, hashes...)
+	}
+	pkgs, err := collectSBOM(paths, mask, fileHashes, hashes[0])
+	if err != nil {
+		return err
+	}
+	var out interface{}
+	switch sbomFormat {
+	case formatSPDX:
+		out, err = newSPDXDocument(pkgs, fileHashes, format)
+	case formatCycloneDX:
+		out, err = newCycloneDXBOM(pkgs, fileHashes, format)
+	}
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stdout, "%s\n", b)
+	return err
+}
+
+func hasHash(hashes []xsum.Hash, name string) bool {
+	for _, h := range hashes {
+		if h.String() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
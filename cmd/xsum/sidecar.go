@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/cli"
+)
+
+// sidecarHashes maps extensions of per-directory checksum files to algorithms
+var sidecarHashes = map[string]string{
+	".sfv":  xsum.HashCRC32,
+	".md5":  xsum.HashMD5,
+	".sha1": xsum.HashSHA1,
+}
+
+func sidecarHash(path string) (string, bool) {
+	alg, ok := sidecarHashes[strings.ToLower(filepath.Ext(path))]
+	return alg, ok
+}
+
+// findSidecars returns all per-directory checksum files under dir in lexical order
+func findSidecars(dir string) ([]string, error) {
+	var out []string
+	err := walk(dir, walkOptions{}, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, ok := sidecarHash(path); ok && fi.Mode().IsRegular() {
+			out = append(out, path)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// readIndexFormat reads SFV and per-directory checksum files based on their extension, and xsum indexes otherwise.
+// Entries with a checksum type (e.g., written by -w with a mask) are read as xsum entries in any file.
+// Relative paths in SFV and per-directory checksum files are resolved relative to dir.
+func readIndexFormat(r io.Reader, path, dir string, opts checkOptions, fn func(xsum.File, string)) {
+	alg, ok := sidecarHash(path)
+	if !ok || dir == "" {
+		readIndex(r, path, dir, opts, fn)
+		return
+	}
+	hash, err := cli.ParseHash(alg)
+	if err != nil {
+		opts.malformed("xsum: %s: invalid algorithm: %s", path, err)
+		return
+	}
+	parse := parseSidecarEntry
+	if alg == xsum.HashCRC32 {
+		parse = parseSFVEntry
+	}
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		entry := strings.TrimRight(scan.Text(), "\r")
+		if s := strings.TrimSpace(entry); s == "" || s[0] == ';' || s[0] == '#' {
+			continue
+		}
+		f := xsum.File{Hash: hash}
+		var fhash string
+		var err error
+		line, escaped := splitEscaped(entry)
+		if p := strings.SplitN(line, "  ", 2); len(p) == 2 && isTypedSum(p[0]) { // written by xsum -w
+			f.Hash, fhash, f.Mask, err = parseTypedSum(p[0], opts.key)
+			if err != nil && !errors.Is(err, cli.ErrKeyRequired) {
+				opts.malformed("xsum: %s: %s", path, err)
+				continue
+			}
+			f.Path = p[1]
+		} else {
+			if alg == xsum.HashCRC32 { // SFV paths are never escaped
+				line, escaped = entry, false
+			}
+			if f.Path, fhash, ok = parse(line); !ok {
+				opts.malformed("xsum: %s: invalid entry `%s'", path, entry)
+				continue
+			}
+		}
+		if escaped {
+			if f.Path, ok = unescapePath(f.Path); !ok {
+				opts.malformed("xsum: %s: invalid escaped path in entry `%s'", path, entry)
+				continue
+			}
+		}
+		reject := opts.keyRejection(path, f.Path, f.Hash, err)
+		if !filepath.IsAbs(f.Path) {
+			f.Path = filepath.Join(dir, f.Path)
+		}
+		if reject != "" {
+			opts.rejected(f.Path, reject)
+			continue
+		}
+		fn(f, strings.ToLower(fhash))
+	}
+}
+
+// parseSFVEntry parses entries of the form: filename CRC32HEX
+func parseSFVEntry(entry string) (path, sum string, ok bool) {
+	entry = strings.TrimSpace(entry)
+	i := strings.LastIndexAny(entry, " \t")
+	if i < 0 {
+		return "", "", false
+	}
+	path = strings.TrimRight(entry[:i], " \t")
+	sum = entry[i+1:]
+	return path, sum, path != "" && len(sum) == 8
+}
+
+// parseSidecarEntry parses entries of the form: HEX  filename or HEX *filename
+func parseSidecarEntry(entry string) (path, sum string, ok bool) {
+	i := strings.IndexByte(entry, ' ')
+	if i < 0 || i+1 == len(entry) {
+		return "", "", false
+	}
+	sum, path = entry[:i], entry[i+1:]
+	if path[0] == '*' || path[0] == ' ' {
+		path = path[1:]
+	}
+	return path, sum, path != "" && sum != ""
+}
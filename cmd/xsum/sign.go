@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ssh"
+)
+
+// Supported signature formats:
+// - minisign: https://jedisct1.github.io/minisign/ (prehashed, with trusted comment)
+// - signify: https://man.openbsd.org/signify (same key encoding as minisign, without trusted comment)
+// - ssh: https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+
+type signatureFormat int
+
+const (
+	formatMinisign signatureFormat = iota
+	formatSignify
+	formatSSH
+)
+
+const (
+	untrustedPrefix = "untrusted comment: "
+	trustedPrefix   = "trusted comment: "
+	sshSigBegin     = "-----BEGIN SSH SIGNATURE-----"
+	sshSigEnd       = "-----END SSH SIGNATURE-----"
+	sshSigMagic     = "SSHSIG"
+	sshSigNamespace = "file" // compatible with ssh-keygen -Y sign -n file
+	sshSigHash      = "sha512"
+)
+
+var (
+	algEd = []byte("Ed") // pure Ed25519
+	algED = []byte("ED") // Ed25519 over BLAKE2b-512 prehash
+)
+
+type secretKey struct {
+	format signatureFormat
+	id     []byte // minisign and signify only
+	key    ed25519.PrivateKey
+}
+
+// readSecretKey reads an unencrypted minisign, signify, or OpenSSH Ed25519 secret key.
+func readSecretKey(path string) (*secretKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(b, []byte("-----BEGIN")) {
+		return parseSSHSecretKey(b)
+	}
+	raw, err := decodeCommented(b)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(raw) == 158 && bytes.Equal(raw[:2], algEd) && string(raw[4:6]) == "B2":
+		// sig_alg[2] kdf_alg[2] chk_alg[2] salt[32] opslimit[8] memlimit[8] id[8] sk[64] chk[32]
+		if raw[2] != 0 || raw[3] != 0 {
+			return nil, errors.New("encrypted minisign keys are unsupported (see minisign -W)")
+		}
+		id, sk, sum := raw[54:62], raw[62:126], raw[126:158]
+		h, _ := blake2b.New256(nil)
+		h.Write(raw[:2])
+		h.Write(id)
+		h.Write(sk)
+		if !bytes.Equal(h.Sum(nil), sum) {
+			return nil, errors.New("invalid minisign key checksum")
+		}
+		return &secretKey{format: formatMinisign, id: id, key: ed25519.PrivateKey(sk)}, nil
+	case len(raw) == 104 && bytes.Equal(raw[:2], algEd) && string(raw[2:4]) == "BK":
+		// pkalg[2] kdfalg[2] kdfrounds[4] salt[16] checksum[8] keynum[8] seckey[64]
+		if binary.BigEndian.Uint32(raw[4:8]) != 0 {
+			return nil, errors.New("encrypted signify keys are unsupported (see signify -n)")
+		}
+		sum, id, sk := raw[24:32], raw[32:40], raw[40:104]
+		if h := sha512.Sum512(sk); !bytes.Equal(h[:8], sum) {
+			return nil, errors.New("invalid signify key checksum")
+		}
+		return &secretKey{format: formatSignify, id: id, key: ed25519.PrivateKey(sk)}, nil
+	}
+	return nil, errors.New("unsupported secret key format")
+}
+
+func parseSSHSecretKey(b []byte) (*secretKey, error) {
+	raw, err := ssh.ParseRawPrivateKey(b)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return nil, errors.New("encrypted SSH keys are unsupported")
+	} else if err != nil {
+		return nil, err
+	}
+	switch k := raw.(type) {
+	case *ed25519.PrivateKey:
+		return &secretKey{format: formatSSH, key: *k}, nil
+	case ed25519.PrivateKey:
+		return &secretKey{format: formatSSH, key: k}, nil
+	}
+	return nil, errors.New("only Ed25519 SSH keys are supported")
+}
+
+// signer calculates a signature over all data written to it
+type signer struct {
+	key  *secretKey
+	hash hash.Hash    // minisign, ssh
+	buf  bytes.Buffer // signify
+}
+
+func newSigner(key *secretKey) *signer {
+	s := &signer{key: key}
+	switch key.format {
+	case formatMinisign:
+		s.hash, _ = blake2b.New512(nil)
+	case formatSSH:
+		s.hash = sha512.New()
+	}
+	return s
+}
+
+func (s *signer) Write(p []byte) (int, error) {
+	if s.hash != nil {
+		return s.hash.Write(p)
+	}
+	return s.buf.Write(p)
+}
+
+// Sign returns the encoded signature file
+func (s *signer) Sign() ([]byte, error) {
+	out := &bytes.Buffer{}
+	switch s.key.format {
+	case formatMinisign:
+		sig := ed25519.Sign(s.key.key, s.hash.Sum(nil))
+		trusted := fmt.Sprintf("timestamp:%d\thashed", time.Now().Unix())
+		global := ed25519.Sign(s.key.key, append(append([]byte{}, sig...), trusted...))
+		fmt.Fprintf(out, "%ssignature from xsum secret key %s\n", untrustedPrefix, keyIDString(s.key.id))
+		fmt.Fprintln(out, base64.StdEncoding.EncodeToString(concat(algED, s.key.id, sig)))
+		fmt.Fprintf(out, "%s%s\n", trustedPrefix, trusted)
+		fmt.Fprintln(out, base64.StdEncoding.EncodeToString(global))
+	case formatSignify:
+		sig := ed25519.Sign(s.key.key, s.buf.Bytes())
+		fmt.Fprintf(out, "%sverify with xsum public key %s\n", untrustedPrefix, keyIDString(s.key.id))
+		fmt.Fprintln(out, base64.StdEncoding.EncodeToString(concat(algEd, s.key.id, sig)))
+	case formatSSH:
+		pub, err := ssh.NewPublicKey(s.key.key.Public())
+		if err != nil {
+			return nil, err
+		}
+		sum := s.hash.Sum(nil)
+		sig := &ssh.Signature{
+			Format: ssh.KeyAlgoED25519,
+			Blob:   ed25519.Sign(s.key.key, sshSigData(sum)),
+		}
+		blob := concat([]byte(sshSigMagic), ssh.Marshal(sshSigBlob{
+			Version:   1,
+			PublicKey: pub.Marshal(),
+			Namespace: sshSigNamespace,
+			HashAlg:   sshSigHash,
+			Signature: ssh.Marshal(sig),
+		}))
+		enc := base64.StdEncoding.EncodeToString(blob)
+		fmt.Fprintln(out, sshSigBegin)
+		for len(enc) > 70 {
+			fmt.Fprintln(out, enc[:70])
+			enc = enc[70:]
+		}
+		fmt.Fprintln(out, enc)
+		fmt.Fprintln(out, sshSigEnd)
+	}
+	return out.Bytes(), nil
+}
+
+type publicKey struct {
+	id  []byte        // minisign and signify only
+	key ssh.PublicKey // ed25519 keys are always wrapped
+}
+
+// readPublicKey reads a minisign, signify, or SSH (authorized_keys format) public key.
+func readPublicKey(path string) (*publicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(b, []byte(untrustedPrefix)) {
+		key, _, _, _, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported public key format: %w", err)
+		}
+		return &publicKey{key: key}, nil
+	}
+	raw, err := decodeCommented(b)
+	if err != nil {
+		return nil, err
+	}
+	// sig_alg[2] id[8] pk[32]
+	if len(raw) != 42 || !bytes.Equal(raw[:2], algEd) {
+		return nil, errors.New("unsupported public key format")
+	}
+	key, err := ssh.NewPublicKey(ed25519.PublicKey(raw[10:42]))
+	if err != nil {
+		return nil, err
+	}
+	return &publicKey{id: raw[2:10], key: key}, nil
+}
+
+// verifySignature verifies an encoded signature file over msg.
+func verifySignature(key *publicKey, msg, sig []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte(sshSigBegin)) {
+		return verifySSHSignature(key, msg, sig)
+	}
+	if key.id == nil {
+		return errors.New("minisign or signify signature requires minisign or signify public key")
+	}
+	pub := key.key.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey)
+	lines := strings.Split(strings.TrimRight(string(sig), "\n"), "\n")
+	if (len(lines) != 2 && len(lines) != 4) || !strings.HasPrefix(lines[0], untrustedPrefix) {
+		return errors.New("invalid signature format")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 74 {
+		return errors.New("invalid signature encoding")
+	}
+	alg, id, edSig := raw[:2], raw[2:10], raw[10:74]
+	if !bytes.Equal(id, key.id) {
+		return fmt.Errorf("signature key ID %s does not match public key ID %s", keyIDString(id), keyIDString(key.id))
+	}
+	switch {
+	case bytes.Equal(alg, algED):
+		h, _ := blake2b.New512(nil)
+		h.Write(msg)
+		msg = h.Sum(nil)
+	case !bytes.Equal(alg, algEd):
+		return errors.New("unsupported signature algorithm")
+	}
+	if !ed25519.Verify(pub, msg, edSig) {
+		return errors.New("signature verification failed")
+	}
+	if len(lines) == 2 { // signify
+		return nil
+	}
+	if !strings.HasPrefix(lines[2], trustedPrefix) {
+		return errors.New("invalid trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return errors.New("invalid trusted comment signature encoding")
+	}
+	trusted := strings.TrimPrefix(strings.TrimRight(lines[2], "\r"), trustedPrefix)
+	if !ed25519.Verify(pub, append(append([]byte{}, edSig...), trusted...), global) {
+		return errors.New("trusted comment verification failed")
+	}
+	return nil
+}
+
+type sshSigBlob struct {
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Signature []byte
+}
+
+func verifySSHSignature(key *publicKey, msg, sig []byte) error {
+	var enc strings.Builder
+	for _, line := range strings.Split(string(sig), "\n") {
+		line = strings.TrimSpace(line)
+		if line != sshSigBegin && line != sshSigEnd {
+			enc.WriteString(line)
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(enc.String())
+	if err != nil || !bytes.HasPrefix(raw, []byte(sshSigMagic)) {
+		return errors.New("invalid SSH signature encoding")
+	}
+	var blob sshSigBlob
+	if err := ssh.Unmarshal(raw[len(sshSigMagic):], &blob); err != nil {
+		return fmt.Errorf("invalid SSH signature: %w", err)
+	}
+	if blob.Version != 1 {
+		return fmt.Errorf("unsupported SSH signature version %d", blob.Version)
+	}
+	if blob.Namespace != sshSigNamespace {
+		return fmt.Errorf("unexpected SSH signature namespace `%s'", blob.Namespace)
+	}
+	if !bytes.Equal(blob.PublicKey, key.key.Marshal()) {
+		return errors.New("SSH signature was not created by public key")
+	}
+	var sum []byte
+	switch blob.HashAlg {
+	case "sha512":
+		h := sha512.Sum512(msg)
+		sum = h[:]
+	default:
+		return fmt.Errorf("unsupported SSH signature hash `%s'", blob.HashAlg)
+	}
+	var s ssh.Signature
+	if err := ssh.Unmarshal(blob.Signature, &s); err != nil {
+		return fmt.Errorf("invalid SSH signature: %w", err)
+	}
+	if err := key.key.Verify(sshSigData(sum), &s); err != nil {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+func sshSigData(sum []byte) []byte {
+	return concat([]byte(sshSigMagic), ssh.Marshal(struct {
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Hash      []byte
+	}{sshSigNamespace, "", sshSigHash, sum}))
+}
+
+// Inline signatures are appended to manifests as comments.
+const inlinePrefix = "# "
+
+func inlineSignature(sig []byte) []byte {
+	out := &bytes.Buffer{}
+	for _, line := range strings.SplitAfter(string(sig), "\n") {
+		if line != "" {
+			out.WriteString(inlinePrefix + line)
+		}
+	}
+	return out.Bytes()
+}
+
+// splitInlineSignature returns the signed portion of a manifest and its inline signature.
+// If no inline signature is present, sig is nil.
+func splitInlineSignature(b []byte) (msg, sig []byte) {
+	for off := 0; off < len(b); {
+		line := b[off:]
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i+1]
+		}
+		if bytes.HasPrefix(line, []byte(inlinePrefix+untrustedPrefix)) ||
+			bytes.HasPrefix(line, []byte(inlinePrefix+sshSigBegin)) {
+			sig := &bytes.Buffer{}
+			for _, l := range bytes.SplitAfter(b[off:], []byte("\n")) {
+				sig.Write(bytes.TrimPrefix(l, []byte(inlinePrefix)))
+			}
+			return b[:off], sig.Bytes()
+		}
+		off += len(line)
+	}
+	return b, nil
+}
+
+func decodeCommented(b []byte) ([]byte, error) {
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], untrustedPrefix) {
+		return nil, errors.New("missing untrusted comment")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encoding: %w", err)
+	}
+	return raw, nil
+}
+
+func keyIDString(id []byte) string {
+	rev := make([]byte, len(id))
+	for i := range id {
+		rev[len(id)-1-i] = id[i]
+	}
+	return strings.ToUpper(hex.EncodeToString(rev))
+}
+
+func concat(bs ...[]byte) []byte {
+	var out []byte
+	for _, b := range bs {
+		out = append(out, b...)
+	}
+	return out
+}
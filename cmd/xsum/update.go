@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sclevine/xsum"
+)
+
+type updateEntry struct {
+	line string
+	file *xsum.File // nil for comments and unparsed entries
+}
+
+// updateManifest rewrites the manifest at path, rehashing entries for files that may have been modified since they were last hashed.
+// Entries for deleted files are removed, and entries for files that are not already listed are appended.
+// Comments and the order of existing entries are preserved, but inline signatures are removed.
+// The size and times of each file are recorded in a state file next to the manifest, and entries without a matching record are always rehashed.
+func updateManifest(path string, files <-chan xsum.File, format outputFormat, opts checkOptions) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	root, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	state, err := readState(statePath(path), b, root)
+	if err != nil {
+		return err
+	}
+	msg, sig := splitInlineSignature(b)
+	if sig != nil {
+		log.Printf("xsum: %s: removing invalidated signature", path)
+	}
+	dir := filepath.Dir(path)
+	if opts.relative && format.root == "" {
+		if format.root, err = filepath.Abs(dir); err != nil {
+			return err
+		}
+	}
+
+	var (
+		entries []updateEntry
+		stale   []xsum.File
+		staleAt []int
+		staleSt []string
+		listed  = map[string]bool{}
+		next    = map[string]string{}
+	)
+	for _, line := range strings.SplitAfter(string(msg), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := updateEntry{line: line}
+		var sum string
+		readIndex(strings.NewReader(line), path, dir, opts, func(f xsum.File, s string) {
+			entry.file = &f
+			sum = s
+		})
+		if entry.file != nil {
+			abs, err := filepath.Abs(entry.file.Path)
+			if err == nil {
+				listed[abs] = true
+			}
+			if _, err := os.Lstat(entry.file.Path); os.IsNotExist(err) {
+				continue
+			}
+			st := fileState(entry.file.Path)
+			if rec := stateRecord(*entry.file, st, sum); err == nil && st != "" && state[abs] == rec {
+				next[abs] = rec
+			} else {
+				stale = append(stale, *entry.file)
+				staleAt = append(staleAt, len(entries))
+				staleSt = append(staleSt, st)
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	var (
+		added   []xsum.File
+		addedSt []string
+	)
+	for f := range files {
+		if f.Stdin {
+			log.Print("xsum: skipping standard input")
+			continue
+		}
+		abs, err := filepath.Abs(f.Path)
+		if err != nil {
+			log.Printf("xsum: %s", err)
+			continue
+		}
+		if !listed[abs] {
+			listed[abs] = true
+			added = append(added, f)
+			addedSt = append(addedSt, fileState(f.Path)) // recorded before hashing
+		}
+	}
+
+	i := 0
+	sum := &xsum.Sum{}
+	if err := sum.EachList(stale, func(n *xsum.Node) error {
+		e := &entries[staleAt[i]]
+		st := staleSt[i]
+		i++
+		if n.Err != nil {
+			log.Printf("xsum: %s", n.Err)
+			return nil
+		}
+		e.line = replaceEntrySum(e.line, n.SumString())
+		recordState(next, n, st)
+		return nil
+	}); err != nil {
+		return err
+	}
+	i = 0
+	sum = &xsum.Sum{NoDirs: format.basic}
+	if err := sum.EachList(added, func(n *xsum.Node) error {
+		st := addedSt[i]
+		i++
+		if n.Err != nil {
+			log.Printf("xsum: %s", n.Err)
+			return nil
+		}
+		entries = append(entries, updateEntry{line: formatChecksum(n, format) + "\n"})
+		recordState(next, n, st)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	for _, e := range entries {
+		out.WriteString(e.line)
+	}
+	if err := writeFileAtomic(path, []byte(out.String())); err != nil {
+		return err
+	}
+	return writeState(statePath(path), []byte(out.String()), root, next)
+}
+
+// statePath returns the path of the state file for the manifest at path.
+func statePath(path string) string {
+	return path + ".xsum-state"
+}
+
+// fileState returns the size and times of a file and its symlink target, or an empty string if they cannot be trusted to detect changes.
+// Directories are never trusted, because changes to their contents may not affect their times.
+// Files modified within the last few seconds are not trusted, because later changes may not affect their times on filesystems with coarse timestamps.
+func fileState(path string) string {
+	var st []string
+	for _, stat := range []func(string) (os.FileInfo, error){os.Lstat, os.Stat} {
+		fi, err := stat(path)
+		if err != nil || fi.IsDir() || time.Since(fi.ModTime()) < 2*time.Second {
+			return ""
+		}
+		var ctime int64
+		if sys, err := xsum.GetSys(fi); err == nil && sys.Ctime != nil {
+			ctime = sys.Ctime.Sec*1e9 + sys.Ctime.Nsec
+		}
+		st = append(st, fmt.Sprintf("%d:%d:%d", fi.Size(), fi.ModTime().UnixNano(), ctime))
+	}
+	return strings.Join(st, " ")
+}
+
+// stateRecord returns the recorded state of a manifest entry, including its algorithm, mask, and checksum.
+func stateRecord(f xsum.File, st, sum string) string {
+	return fmt.Sprintf("%s %s:%s:%s", st, f.Hash, sum, f.Mask)
+}
+
+func recordState(state map[string]string, n *xsum.Node, st string) {
+	if st == "" {
+		return
+	}
+	if abs, err := filepath.Abs(n.Path); err == nil {
+		state[abs] = stateRecord(n.File, st, n.SumString())
+	}
+}
+
+// stateVersion identifies the format of state files.
+const stateVersion = "#xsum-state 1"
+
+// stateHeader returns the first line of the state file for a manifest.
+// State files are only used with the manifest they were written for, so that manifests edited by hand are fully rehashed.
+func stateHeader(manifest []byte) string {
+	sum := sha256.Sum256(manifest)
+	return stateVersion + " sha256:" + hex.EncodeToString(sum[:])
+}
+
+// readState reads a state file, which lists the path (relative to root) and recorded state of each manifest entry.
+// A missing state file, or a state file for a different version of the manifest, results in every entry being rehashed.
+func readState(path string, manifest []byte, root string) (map[string]string, error) {
+	state := map[string]string{}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scan := bufio.NewScanner(f)
+	if !scan.Scan() || scan.Text() != stateHeader(manifest) {
+		log.Printf("xsum: %s: state does not match manifest, rehashing all entries", path)
+		return state, scan.Err()
+	}
+	for scan.Scan() {
+		line := scan.Text()
+		quoted, err := strconv.QuotedPrefix(line)
+		if err != nil || len(line) <= len(quoted)+1 {
+			continue
+		}
+		p, err := strconv.Unquote(quoted)
+		if err != nil {
+			continue
+		}
+		if p = filepath.FromSlash(p); !filepath.IsAbs(p) {
+			p = filepath.Join(root, p)
+		}
+		state[p] = line[len(quoted)+1:]
+	}
+	return state, scan.Err()
+}
+
+// writeState writes a state file for manifest, with paths relative to root when possible.
+func writeState(path string, manifest []byte, root string, state map[string]string) error {
+	paths := make([]string, 0, len(state))
+	for p := range state {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var out strings.Builder
+	out.WriteString(stateHeader(manifest) + "\n")
+	for _, p := range paths {
+		rel := p
+		if r, err := filepath.Rel(root, p); err == nil {
+			rel = filepath.ToSlash(r)
+		}
+		out.WriteString(strconv.Quote(rel) + " " + state[p] + "\n")
+	}
+	return writeFileAtomic(path, []byte(out.String()))
+}
+
+// replaceEntrySum replaces the checksum in a manifest entry, preserving its type, mask, and path
+func replaceEntrySum(line, sum string) string {
+	line, escaped := splitEscaped(line)
+	fields := strings.SplitN(line, "  ", 2)
+	p := strings.SplitN(fields[0], ":", 3)
+	if len(p) > 1 {
+		p[1] = sum
+	} else {
+		p[0] = sum
+	}
+	entry := strings.Join(p, ":") + "  " + fields[1]
+	if escaped {
+		return `\` + entry
+	}
+	return entry
+}
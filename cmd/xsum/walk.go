@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sclevine/xsum"
+)
+
+// walkOptions controls how walk treats symlinks.
+type walkOptions struct {
+	follow     bool // report the targets of symlinks instead of the symlinks
+	followDirs bool // descend into the targets of followed symlinks to directories
+}
+
+// walkFunc is called by walk for each path, with the file's info and any error encountered when reading it.
+// If a followed symlink cannot be resolved, fi describes the symlink.
+// If a directory cannot be read or would form a symlink cycle, fn is called with the directory's info and the error.
+// Returning an error stops the walk.
+type walkFunc func(path string, fi os.FileInfo, err error) error
+
+// walk calls fn for root and each path under root in lexical order, including directories.
+func walk(root string, opts walkOptions, fn walkFunc) error {
+	return walkPath(root, opts, nil, fn)
+}
+
+func walkPath(path string, opts walkOptions, ancestors []os.FileInfo, fn walkFunc) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return fn(path, nil, err)
+	}
+	descend := fi.IsDir()
+	if fi.Mode()&os.ModeSymlink != 0 && opts.follow {
+		target, err := os.Stat(path)
+		if err != nil {
+			return fn(path, fi, err)
+		}
+		fi = target
+		descend = fi.IsDir() && opts.followDirs
+	}
+	if descend {
+		for _, a := range ancestors {
+			if os.SameFile(a, fi) {
+				return fn(path, fi, fmt.Errorf("%s: symlink cycle", path))
+			}
+		}
+	}
+	if err := fn(path, fi, nil); err != nil || !descend {
+		return err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return fn(path, fi, err)
+	}
+	ancestors = append(ancestors, fi)
+	for _, e := range entries {
+		if err := walkPath(filepath.Join(path, e.Name()), opts, ancestors, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkFiles returns the path of each regular file under root in lexical order.
+// Symlinks are followed, so that files are never omitted silently, and broken symlinks or symlink cycles result in an error.
+// Special files are skipped with a warning.
+// If root is not a directory, only root is returned.
+func walkFiles(root string) ([]string, error) {
+	var out []string
+	err := walk(root, walkOptions{follow: true, followDirs: true}, func(path string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case fi.Mode().IsRegular(), path == root && !fi.IsDir():
+			out = append(out, path)
+		case !fi.IsDir():
+			log.Printf("xsum: %s: skipping special file", path)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func openPathList(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// scanPathList returns a scanner for paths in r separated by sep.
+// Empty paths are skipped, and carriage returns are removed from newline-separated paths.
+func scanPathList(r io.Reader, sep byte) *bufio.Scanner {
+	scan := bufio.NewScanner(r)
+	scan.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		for i := 0; i < len(data); i++ {
+			if data[i] == sep {
+				return i + 1, data[:i], nil
+			}
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	return scan
+}
+
+func trimPath(path string, sep byte) string {
+	if sep == '\n' {
+		return strings.TrimSuffix(path, "\r")
+	}
+	return path
+}
+
+// readPathList returns all paths in r separated by sep
+func readPathList(r io.Reader, sep byte) ([]string, error) {
+	var out []string
+	scan := scanPathList(r, sep)
+	for scan.Scan() {
+		if path := trimPath(scan.Text(), sep); path != "" {
+			out = append(out, path)
+		}
+	}
+	return out, scan.Err()
+}
+
+// listFiles sends a File for each path in r separated by sep, as it is read.
+// Paths are never treated as standard input, so r may be standard input.
+func listFiles(r io.Reader, name string, sep byte, mask xsum.Mask, hash xsum.Hash) <-chan xsum.File {
+	ch := make(chan xsum.File, 1)
+	go func() {
+		defer close(ch)
+		scan := scanPathList(r, sep)
+		for scan.Scan() {
+			if path := trimPath(scan.Text(), sep); path != "" {
+				ch <- xsum.File{Hash: hash, Path: path, Mask: mask}
+			}
+		}
+		if err := scan.Err(); err != nil {
+			log.Printf("xsum: %s: %s", name, err)
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sclevine/xsum"
+)
+
+const (
+	writeSkip      = "skip"
+	writeOverwrite = "overwrite"
+	writeVerify    = "verify"
+)
+
+// writeChecksums writes a separate, adjacent file for each checksum, or a combined manifest for each directory if combined is set.
+// Existing files are left unchanged, atomically replaced, or validated depending on mode.
+// When validating, output is restricted by level, like -c.
+func writeChecksums(files <-chan xsum.File, format outputFormat, ext, mode string, combined bool, level outputLevel) error {
+	var (
+		dirs   []string
+		nodes  = map[string][]*xsum.Node{}
+		failed int
+	)
+	sum := &xsum.Sum{NoDirs: format.basic}
+	if err := sum.Each(files, func(n *xsum.Node) error {
+		if n.Err != nil {
+			log.Printf("xsum: %s", n.Err)
+			return nil
+		}
+		if n.Stdin {
+			log.Print("xsum: skipping standard input")
+			return nil
+		}
+		abs, err := filepath.Abs(n.Path)
+		if err != nil {
+			log.Printf("xsum: %s", err)
+			return nil
+		}
+		if !combined {
+			failed += writeIndex(abs+"."+ext, []*xsum.Node{n}, format, mode, level)
+			return nil
+		}
+		dir, name := filepath.Split(abs)
+		if name == combinedName(ext) {
+			return nil // never include manifest in itself
+		}
+		if _, ok := nodes[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		nodes[dir] = append(nodes[dir], n)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		failed += writeIndex(filepath.Join(dir, combinedName(ext)), nodes[dir], format, mode, level)
+	}
+	if failed > 0 {
+		cErr := &CheckError{Code: ExitFailed}
+		if level != outputStatus {
+			cErr.Warnings = append(cErr.Warnings, fmt.Sprintf("WARNING: %d computed checksum%s did NOT match", failed, plural(failed)))
+		}
+		return cErr
+	}
+	return nil
+}
+
+func combinedName(ext string) string {
+	return strings.ToUpper(ext) + "SUMS"
+}
+
+// writeIndex writes checksums for nodes to path, with paths relative to the directory containing path.
+// If mode is writeVerify, the existing file is validated instead, and the number of failed checksums is returned.
+func writeIndex(path string, nodes []*xsum.Node, format outputFormat, mode string, level outputLevel) (failed int) {
+	format.root = filepath.Dir(path) // resolved relative to index
+	if mode == writeVerify {
+		return verifyIndex(path, nodes, level)
+	}
+	var out strings.Builder
+	for _, n := range nodes {
+		out.WriteString(formatChecksum(n, format) + "\n")
+	}
+	var err error
+	if mode == writeOverwrite {
+		err = writeFileAtomic(path, []byte(out.String()))
+	} else {
+		err = writeFileExcl(path, []byte(out.String()))
+	}
+	if os.IsExist(err) {
+		log.Printf("xsum: skipping existing %s", path)
+	} else if err != nil {
+		log.Printf("xsum: %s", err)
+	}
+	return 0
+}
+
+// verifyIndex validates the checksums of nodes against the existing index at path
+func verifyIndex(path string, nodes []*xsum.Node, level outputLevel) (failed int) {
+	sums := map[string]string{}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("xsum: %s", err)
+	} else {
+		opts := checkOptions{hash: nodes[0].Hash, relative: true}
+		readIndex(f, path, filepath.Dir(path), opts, func(f xsum.File, sum string) {
+			if abs, err := filepath.Abs(f.Path); err == nil {
+				sums[abs] = f.Hash.String() + ":" + sum
+			}
+		})
+		f.Close()
+	}
+	for _, n := range nodes {
+		abs, err := filepath.Abs(n.Path)
+		if err == nil && sums[abs] == n.Hash.String()+":"+n.SumString() {
+			if level != outputStatus && level != outputQuiet {
+				fmt.Println(checkedPath(n.Path) + ": OK")
+			}
+		} else {
+			if level != outputStatus {
+				fmt.Println(checkedPath(n.Path) + ": FAILED")
+			}
+			failed++
+		}
+	}
+	return failed
+}
+
+func writeFileExcl(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// writeFileAtomic writes a temporary file adjacent to path and renames it to path, preserving the permissions of the existing file.
+// If path does not exist, it is created directly, so that its permissions respect the umask.
+func writeFileAtomic(path string, data []byte) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if err := writeFileExcl(path, data); !os.IsExist(err) {
+			return err
+		}
+		fi, err = os.Stat(path) // created concurrently
+	}
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(fi.Mode().Perm()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
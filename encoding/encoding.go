@@ -76,7 +76,14 @@ XSum DEFINITIONS  ::=  BEGIN
         fnv64       (27),
         fnv64a      (28),
         fnv128      (29),
-        fnv128a     (30)
+        fnv128a     (30),
+        sha256-tree (31),
+        hmac-sha256 (32),
+        hmac-sha512 (33),
+        blake2s256-keyed (34),
+        blake2b256-keyed (35),
+        blake2b384-keyed (36),
+        blake2b512-keyed (37)
     }
 END
 */
@@ -180,6 +187,17 @@ const (
 	HashFNV128
 	HashFNV128a
 
+	// tree
+	HashSHA256Tree
+
+	// keyed
+	HashHMACSHA256
+	HashHMACSHA512
+	HashBlake2s256Keyed
+	HashBlake2b256Keyed
+	HashBlake2b384Keyed
+	HashBlake2b512Keyed
+
 	HashUnknown HashType = -1
 )
 
@@ -1,11 +1,13 @@
 module github.com/sclevine/xsum
 
-go 1.17
+go 1.18
 
 require (
 	github.com/jessevdk/go-flags v1.4.0
 	github.com/pkg/xattr v0.4.5
+	github.com/tetratelabs/wazero v1.0.0
 	golang.org/x/crypto v0.0.0-20200728195943-123391ffb6de
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
-	golang.org/x/sys v0.0.0-20220209214540-3681064d5158 // indirect
+	golang.org/x/sys v0.0.0-20220209214540-3681064d5158
 )
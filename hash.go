@@ -2,12 +2,15 @@ package xsum
 
 import (
 	"bytes"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
 	"os"
 	"os/exec"
+	"sync"
+	"time"
 
 	"github.com/sclevine/xsum/encoding"
 )
@@ -32,6 +35,7 @@ const (
 	HashBlake2b384 = "blake2b384"
 	HashBlake2b512 = "blake2b512"
 	HashRMD160     = "rmd160"
+	HashSHA256Tree = "sha256-tree"
 	HashCRC32      = "crc32"
 	HashCRC32c     = "crc32c"
 	HashCRC32k     = "crc32k"
@@ -44,8 +48,42 @@ const (
 	HashFNV64a     = "fnv64a"
 	HashFNV128     = "fnv128"
 	HashFNV128a    = "fnv128a"
+
+	HashHMACSHA256      = "hmac-sha256"
+	HashHMACSHA512      = "hmac-sha512"
+	HashBlake2s256Keyed = "blake2s256-keyed"
+	HashBlake2b256Keyed = "blake2b256-keyed"
+	HashBlake2b384Keyed = "blake2b384-keyed"
+	HashBlake2b512Keyed = "blake2b512-keyed"
+)
+
+var (
+	hashTypesMu sync.RWMutex
+	hashTypes   = map[string]encoding.HashType{}
 )
 
+// RegisterHashType registers the type used to identify a Hash with the provided name in DER-encoded metadata.
+// The name must match the value returned by the Hash's String method.
+// Hashes without a built-in or registered type are identified as encoding.HashUnknown.
+func RegisterHashType(name string, hashType encoding.HashType) error {
+	if hashToEncoding(name) != encoding.HashUnknown {
+		return fmt.Errorf("hash type for `%s' already registered", name)
+	}
+	hashTypesMu.Lock()
+	defer hashTypesMu.Unlock()
+	if _, ok := hashTypes[name]; ok {
+		return fmt.Errorf("hash type for `%s' already registered", name)
+	}
+	hashTypes[name] = hashType
+	return nil
+}
+
+// LookupHashType returns the type used to identify a Hash with the provided name in DER-encoded metadata.
+// Hashes without a built-in or registered type are identified as encoding.HashUnknown.
+func LookupHashType(name string) encoding.HashType {
+	return hashToEncoding(name)
+}
+
 func hashToEncoding(h string) encoding.HashType {
 	switch h {
 	case HashNone:
@@ -110,7 +148,26 @@ func hashToEncoding(h string) encoding.HashType {
 		return encoding.HashFNV128
 	case HashFNV128a:
 		return encoding.HashFNV128a
+	case HashSHA256Tree:
+		return encoding.HashSHA256Tree
+	case HashHMACSHA256:
+		return encoding.HashHMACSHA256
+	case HashHMACSHA512:
+		return encoding.HashHMACSHA512
+	case HashBlake2s256Keyed:
+		return encoding.HashBlake2s256Keyed
+	case HashBlake2b256Keyed:
+		return encoding.HashBlake2b256Keyed
+	case HashBlake2b384Keyed:
+		return encoding.HashBlake2b384Keyed
+	case HashBlake2b512Keyed:
+		return encoding.HashBlake2b512Keyed
 	default:
+		hashTypesMu.RLock()
+		defer hashTypesMu.RUnlock()
+		if t, ok := hashTypes[h]; ok {
+			return t
+		}
 		return encoding.HashUnknown
 	}
 }
@@ -132,13 +189,31 @@ func NewHashFunc(name string, fn func() hash.Hash) Hash {
 	}
 }
 
-// NewHashPlugin returns a Hash backed by the xsum plugin at the specified path.
+// NewHashPlugin returns a PluginHash backed by the xsum plugin at the specified path.
 // File()/Data() and Metadata() may use different underlying hash functions.
+// The plugin inherits the environment of the current process.
 // See PLUGIN.md for details.
-func NewHashPlugin(name, path string) Hash {
+func NewHashPlugin(name, path string) PluginHash {
+	return NewHashPluginLimits(name, path, PluginLimits{})
+}
+
+// NewHashPluginLimits is similar to NewHashPlugin, but the plugin is executed within the provided limits.
+func NewHashPluginLimits(name, path string, limits PluginLimits) PluginHash {
 	return &hashPlugin{
-		name: name,
-		path: path,
+		name:   name,
+		path:   path,
+		limits: limits,
+	}
+}
+
+// NewHashPluginRuntime is similar to NewHashPluginLimits, but the plugin is executed by rt instead of the operating system.
+// See package github.com/sclevine/xsum/wasm for WebAssembly plugins.
+func NewHashPluginRuntime(name, path string, limits PluginLimits, rt PluginRuntime) PluginHash {
+	return &hashPlugin{
+		name:    name,
+		path:    path,
+		limits:  limits,
+		runtime: rt,
 	}
 }
 
@@ -176,47 +251,175 @@ func (h *hashFunc) File(path string) ([]byte, error) {
 	return h.Data(f)
 }
 
+// warningHash is implemented by Hashes that may report warnings for individual checksums.
+type warningHash interface {
+	fileWarnings(path string) ([]byte, []PluginWarning, error)
+	dataWarnings(r io.Reader) ([]byte, []PluginWarning, error)
+}
+
 type hashPlugin struct {
 	name, path string
+	limits     PluginLimits
+	runtime    PluginRuntime // nil for executable plugins
+	info       pluginInfo
 }
 
 func (h *hashPlugin) String() string {
 	return h.name
 }
 
+func (h *hashPlugin) Path() string {
+	return h.path
+}
+
+func (h *hashPlugin) Info() (*PluginInfo, error) {
+	return h.info.get(h.name, func() ([]byte, bool, error) {
+		stdout, _, err := h.exec([]string{PluginInfoArg}, nil, "")
+		if _, ok := err.(*exec.ExitError); ok || (err != nil && h.runtime != nil && !errors.Is(err, ErrPluginTimeout)) {
+			return nil, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		return stdout.Bytes(), true, nil
+	})
+}
+
 func (h *hashPlugin) Metadata(b []byte) ([]byte, error) {
-	return h.readCmd(bytes.NewReader(b), "metadata")
+	sum, _, err := h.readCmd(bytes.NewReader(b), PluginMetadata)
+	return sum, err
 }
 
 func (h *hashPlugin) Data(r io.Reader) ([]byte, error) {
-	return h.readCmd(r, "data")
+	sum, _, err := h.dataWarnings(r)
+	return sum, err
 }
 
 func (h *hashPlugin) File(path string) ([]byte, error) {
-	return h.argCmd(path, "data")
+	sum, _, err := h.fileWarnings(path)
+	return sum, err
 }
 
-func (h *hashPlugin) readCmd(r io.Reader, ptype string) ([]byte, error) {
-	cmd := exec.Command(h.path)
-	cmd.Env = append(os.Environ(), "XSUM_PLUGIN_TYPE="+ptype)
-	cmd.Stdin = r
-	sum, err := cmd.Output()
-	if eErr, ok := err.(*exec.ExitError); ok {
-		return nil, fmt.Errorf("plugin error:\n\t%s", string(eErr.Stderr))
-	} else if err != nil {
-		return nil, err
+func (h *hashPlugin) dataWarnings(r io.Reader) ([]byte, []PluginWarning, error) {
+	return h.readCmd(r, PluginData)
+}
+
+func (h *hashPlugin) fileWarnings(path string) ([]byte, []PluginWarning, error) {
+	if h.runtime != nil { // runtimes only receive input via stdin
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		return h.readCmd(f, PluginData)
 	}
-	return hex.DecodeString(string(sum))
+	return h.argCmd(path, PluginData)
 }
 
-func (h *hashPlugin) argCmd(path, ptype string) ([]byte, error) {
-	cmd := exec.Command(h.path, path)
-	cmd.Env = append(os.Environ(), "XSUM_PLUGIN_TYPE="+ptype)
-	sum, err := cmd.Output()
-	if eErr, ok := err.(*exec.ExitError); ok {
-		return nil, fmt.Errorf("plugin error:\n\t%s", string(eErr.Stderr))
-	} else if err != nil {
-		return nil, err
+func (h *hashPlugin) readCmd(r io.Reader, ptype string) ([]byte, []PluginWarning, error) {
+	return h.run(nil, r, ptype)
+}
+
+func (h *hashPlugin) argCmd(path, ptype string) ([]byte, []PluginWarning, error) {
+	return h.run([]string{path}, nil, ptype)
+}
+
+// run executes a plugin and decodes its checksum.
+// Warnings reported by the plugin are written to stderr and returned.
+func (h *hashPlugin) run(args []string, stdin io.Reader, ptype string) ([]byte, []PluginWarning, error) {
+	sum, warnings, err := h.checksum(args, stdin, ptype)
+	if err != nil {
+		return nil, nil, &PluginError{Name: h.name, Path: h.path, Err: err}
+	}
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, w)
+	}
+	return sum, warnings, nil
+}
+
+func (h *hashPlugin) checksum(args []string, stdin io.Reader, ptype string) ([]byte, []PluginWarning, error) {
+	info, err := h.Info()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid plugin: %w", err)
+	}
+	if err := checkType(info, ptype); err != nil {
+		return nil, nil, err
+	}
+	stdout, stderr, err := h.exec(args, stdin, ptype)
+	if err != nil {
+		return nil, nil, pluginFailure(err, stderr)
+	}
+	if stdout.truncated {
+		return nil, nil, fmt.Errorf("output exceeds %d bytes", maxPluginOutput)
+	}
+	sum, err := decodeSum(info, stdout.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	return sum, parseWarnings(h.name, stderr.String()), nil
+}
+
+// exec executes a plugin with limited output, stopping it if it times out.
+func (h *hashPlugin) exec(args []string, stdin io.Reader, ptype string) (stdout, stderr *limitedBuffer, err error) {
+	stdout = &limitedBuffer{limit: maxPluginOutput}
+	stderr = &limitedBuffer{limit: h.limits.stderr()}
+	run := func(ctx context.Context) error {
+		if h.runtime != nil {
+			var env []string
+			if ptype != "" {
+				env = append(env, "XSUM_PLUGIN_TYPE="+ptype)
+			}
+			return h.runtime.Exec(ctx, h.path, args, env, stdin, stdout, stderr)
+		}
+		return h.execCmd(ctx, args, stdin, ptype, stdout, stderr)
+	}
+	if h.limits.Timeout <= 0 {
+		return stdout, stderr, run(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.limits.Timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx)
+	}()
+	select {
+	case err := <-done:
+		if err == nil || ctx.Err() == nil {
+			return stdout, stderr, err
+		}
+	case <-ctx.Done():
+		select {
+		case <-done:
+		case <-time.After(time.Second): // input or output may not be interruptible
+		}
+	}
+	return nil, nil, fmt.Errorf("%w after %s", ErrPluginTimeout, h.limits.Timeout)
+}
+
+// execCmd executes an executable plugin with a limited environment and resources, and kills it once ctx is done.
+func (h *hashPlugin) execCmd(ctx context.Context, args []string, stdin io.Reader, ptype string, stdout, stderr io.Writer) error {
+	cmd, err := limitCmd(h.path, args, &h.limits)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = stdin
+	cmd.Env = h.limits.environ(ptype)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if h.limits.Timeout > 0 {
+		// plugins without a timeout remain in the foreground process group, so that they receive terminal signals
+		setProcessGroup(cmd)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
 	}
-	return hex.DecodeString(string(sum))
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd.Process)
+		case <-exited:
+		}
+	}()
+	return cmd.Wait()
 }
@@ -5,6 +5,8 @@ import (
 	"io"
 	"os"
 
+	"golang.org/x/sync/semaphore"
+
 	"github.com/sclevine/xsum/encoding"
 )
 
@@ -15,10 +17,25 @@ type File struct {
 	Stdin bool
 }
 
-func (f *File) sum() ([]byte, error) {
+// sem must already be acquired once by the caller
+func (f *File) sum(sem *semaphore.Weighted) ([]byte, []PluginWarning, error) {
+	if h, ok := f.Hash.(warningHash); ok {
+		if f.Stdin {
+			return h.dataWarnings(io.NopCloser(os.Stdin))
+		}
+		return h.fileWarnings(f.Path)
+	}
+	sum, err := f.hash(sem)
+	return sum, nil, err
+}
+
+func (f *File) hash(sem *semaphore.Weighted) ([]byte, error) {
 	if f.Stdin {
 		return f.Hash.Data(io.NopCloser(os.Stdin))
 	}
+	if h, ok := f.Hash.(concurrentHash); ok {
+		return h.concurrentFile(f.Path, sem)
+	}
 	return f.Hash.File(f.Path)
 }
 
@@ -55,6 +72,9 @@ type Node struct {
 	Sys   *Sys
 	Xattr *Xattr
 	Err   error
+
+	// Warnings are reported by plugins for the file, or for any file within the directory.
+	Warnings []PluginWarning
 }
 
 type Sys struct {
@@ -63,6 +83,12 @@ type Sys struct {
 	Rdev         *uint64
 }
 
+// GetSys returns the Sys data available for a file on the current platform.
+// If no Sys data is available, ErrNoStat is returned.
+func GetSys(fi os.FileInfo) (*Sys, error) {
+	return getSys(fi)
+}
+
 type Xattr struct {
 	HashType encoding.HashType
 	Hashes   []encoding.NamedHash
@@ -0,0 +1,318 @@
+package xsum
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PluginInfoArg is the sole argument provided to a plugin to request its PluginInfo.
+const PluginInfoArg = "--xsum-info"
+
+// Plugin input types, provided to plugins via XSUM_PLUGIN_TYPE.
+const (
+	PluginData     = "data"
+	PluginMetadata = "metadata"
+)
+
+// ErrPluginTimeout is returned (wrapped in a PluginError) when a plugin exceeds PluginLimits.Timeout.
+var ErrPluginTimeout = errors.New("timed out")
+
+// DefaultPluginEnv lists environment variables that plugins commonly need to run.
+// It is intended as a starting point for PluginLimits.Env.
+var DefaultPluginEnv = []string{
+	"PATH", "PATHEXT", "SYSTEMROOT", "TMPDIR", "TMP", "TEMP",
+	"HOME", "USERPROFILE", "LD_LIBRARY_PATH",
+	"LANG", "LANGUAGE", "LC_ALL", "LC_CTYPE", "LC_COLLATE", "LC_MESSAGES", "LC_NUMERIC", "LC_TIME",
+}
+
+// DefaultPluginStderr is the number of bytes of stderr captured from each plugin execution when PluginLimits.Stderr is zero.
+const DefaultPluginStderr = 64 << 10
+
+// maxPluginOutput is the maximum size of plugin output on stdout.
+const maxPluginOutput = 64 << 10
+
+// PluginLimits restricts the execution of plugins.
+// The zero value applies no limits, except for the defaults documented below.
+type PluginLimits struct {
+	// Timeout limits the duration of each plugin execution.
+	// Executable plugins are killed when they time out, along with any child processes (except on Windows).
+	// To make this possible, executable plugins with a timeout are started in a new process group, so they do not receive terminal signals (e.g., SIGINT) directly.
+	// Plugins executed by a PluginRuntime are signaled via the context passed to PluginRuntime.Exec.
+	Timeout time.Duration
+
+	// Env lists the names of environment variables that are passed to executable plugins, in addition to XSUM_PLUGIN_TYPE.
+	// If Env is nil, plugins inherit the entire environment.
+	// To restrict plugins to a minimal environment, set Env to DefaultPluginEnv (plus any other required variables).
+	Env []string
+
+	// CPUTime limits the CPU time of each plugin execution (RLIMIT_CPU, executable plugins on Linux only).
+	// CPU time and memory limits are applied with setrlimit by re-executing the current binary before the plugin is executed.
+	CPUTime time.Duration
+
+	// Memory limits the memory of each plugin execution in bytes.
+	// For executable plugins, Memory limits the address space (RLIMIT_AS, Linux only).
+	// Address space includes memory that is reserved but unused, so plugins written in Go may require several GiB.
+	// For plugins executed by a PluginRuntime, Memory is applied by the runtime (see package wasm).
+	Memory int64
+
+	// Stderr limits the number of bytes of stderr captured from each plugin execution.
+	// Further output is discarded. If Stderr is zero, DefaultPluginStderr is used.
+	Stderr int
+}
+
+func (l *PluginLimits) stderr() int {
+	if l.Stderr <= 0 {
+		return DefaultPluginStderr
+	}
+	return l.Stderr
+}
+
+// environ returns the environment for an executable plugin.
+func (l *PluginLimits) environ(ptype string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		i := strings.Index(kv, "=")
+		if i <= 0 || envMatch(kv[:i], "XSUM_PLUGIN_TYPE") {
+			continue
+		}
+		if l.Env == nil {
+			env = append(env, kv)
+			continue
+		}
+		for _, name := range l.Env {
+			if envMatch(kv[:i], name) {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	if ptype != "" {
+		env = append(env, "XSUM_PLUGIN_TYPE="+ptype)
+	}
+	return env
+}
+
+// envMatch returns true if two environment variable names are equivalent.
+func envMatch(a, b string) bool {
+	return a == b || (runtime.GOOS == "windows" && strings.EqualFold(a, b))
+}
+
+// PluginError describes the failure of a plugin.
+type PluginError struct {
+	Name string // plugin name, without the xsum- prefix
+	Path string
+	Err  error
+}
+
+// Error message
+func (e *PluginError) Error() string {
+	return fmt.Sprintf("plugin xsum-%s: %s", e.Name, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *PluginError) Unwrap() error {
+	return e.Err
+}
+
+// limitedBuffer is a buffer that discards writes beyond its limit.
+// Writes never fail, so that plugins are not interrupted by excessive output.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if rem := b.limit - b.buf.Len(); len(p) > rem {
+		p = p[:rem]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+// pluginFailure describes a failed plugin execution, including its stderr.
+func pluginFailure(err error, stderr *limitedBuffer) error {
+	if stderr == nil {
+		return err
+	}
+	msg := strings.TrimRight(stderr.String(), "\n")
+	if msg == "" {
+		return err
+	}
+	if stderr.truncated {
+		msg += "\n\t[stderr truncated]"
+	}
+	return fmt.Errorf("%s:\n\t%s", err, strings.ReplaceAll(msg, "\n", "\n\t"))
+}
+
+// PluginWarning is a warning reported by a plugin that succeeded.
+// See PLUGIN.md for details.
+type PluginWarning struct {
+	Name     string // plugin name, without the xsum- prefix
+	Category string // e.g., "lossy" or "unverified", or empty if the warning has no category
+	Message  string
+}
+
+// String formats the warning as it is passed through to stderr.
+func (w PluginWarning) String() string {
+	if w.Category == "" {
+		return fmt.Sprintf("xsum-%s: %s", w.Name, w.Message)
+	}
+	return fmt.Sprintf("xsum-%s: warning: %s: %s", w.Name, w.Category, w.Message)
+}
+
+// parseWarnings parses the stderr of a successful plugin.
+// Lines that are not of the form "warning: [category]: [message]" are returned without a category.
+func parseWarnings(name, stderr string) []PluginWarning {
+	var warnings []PluginWarning
+	for _, line := range strings.Split(strings.TrimRight(stderr, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		w := PluginWarning{Name: name, Message: line}
+		if p := strings.SplitN(line, ": ", 3); len(p) == 3 && p[0] == "warning" && p[1] != "" {
+			w.Category, w.Message = p[1], p[2]
+		}
+		warnings = append(warnings, w)
+	}
+	return warnings
+}
+
+// PluginInfo describes a plugin.
+// Plugins output PluginInfo as JSON when executed with PluginInfoArg.
+// See PLUGIN.md for details.
+type PluginInfo struct {
+	// Name is the name of the plugin, without the xsum- prefix.
+	Name string `json:"name"`
+
+	// Version is the version of the plugin.
+	Version string `json:"version"`
+
+	// Length is the length of each checksum in bytes.
+	Length int `json:"length"`
+
+	// Types are the supported input types (PluginData and/or PluginMetadata).
+	Types []string `json:"types"`
+}
+
+// Supports returns true if the plugin accepts input of the provided type.
+func (p *PluginInfo) Supports(ptype string) bool {
+	for _, t := range p.Types {
+		if t == ptype {
+			return true
+		}
+	}
+	return false
+}
+
+// PluginHash is a Hash backed by an xsum plugin.
+type PluginHash interface {
+	Hash
+
+	// Path returns the path to the plugin file.
+	Path() string
+
+	// Info returns the PluginInfo reported by the plugin, after validating it.
+	// Info returns nil (with no error) for plugins that do not support PluginInfoArg.
+	// The plugin is only executed on the first call.
+	Info() (*PluginInfo, error)
+}
+
+// PluginRuntime executes plugins that are not native executables (e.g., WebAssembly plugins).
+type PluginRuntime interface {
+	// Exec executes the plugin at path with args (excluding the program name), and with env (NAME=value) as its only environment variables.
+	// Input is provided via stdin, which may be nil.
+	// Exec should return as soon as possible once ctx is done.
+	// Any error is treated as a failure of the plugin.
+	Exec(ctx context.Context, path string, args, env []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+// pluginInfo requests PluginInfo from a plugin once.
+type pluginInfo struct {
+	once sync.Once
+	info *PluginInfo
+	err  error
+}
+
+// get returns the PluginInfo for the named plugin.
+// The handshake function must execute the plugin with PluginInfoArg, and return its output and whether it succeeded.
+func (p *pluginInfo) get(name string, handshake func() (out []byte, ok bool, err error)) (*PluginInfo, error) {
+	p.once.Do(func() {
+		out, ok, err := handshake()
+		if err != nil {
+			p.err = err
+			return
+		}
+		out = bytes.TrimSpace(out)
+		if !ok || !bytes.HasPrefix(out, []byte("{")) {
+			return // PluginInfoArg not supported
+		}
+		p.info, p.err = parsePluginInfo(name, out)
+	})
+	return p.info, p.err
+}
+
+func parsePluginInfo(name string, b []byte) (*PluginInfo, error) {
+	var info PluginInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, fmt.Errorf("invalid %s output: %w", PluginInfoArg, err)
+	}
+	if info.Name != name {
+		return nil, fmt.Errorf("plugin reports name `%s', expected `%s'", info.Name, name)
+	}
+	if info.Length <= 0 {
+		return nil, fmt.Errorf("plugin reports invalid checksum length %d", info.Length)
+	}
+	if len(info.Types) == 0 {
+		return nil, fmt.Errorf("plugin reports no input types")
+	}
+	for _, t := range info.Types {
+		if t != PluginData && t != PluginMetadata {
+			return nil, fmt.Errorf("plugin reports unknown input type `%s'", t)
+		}
+	}
+	return &info, nil
+}
+
+// checkType returns an error if a plugin does not support the provided input type.
+func checkType(info *PluginInfo, ptype string) error {
+	if info != nil && !info.Supports(ptype) {
+		return fmt.Errorf("%s input not supported", ptype)
+	}
+	return nil
+}
+
+// decodeSum decodes the output of a plugin, and validates it against the plugin's PluginInfo.
+func decodeSum(info *PluginInfo, out []byte) ([]byte, error) {
+	sum, err := hex.DecodeString(string(out))
+	if err != nil {
+		if len(out) > 64 {
+			out = append(out[:64:64], "..."...)
+		}
+		return nil, fmt.Errorf("output is not a hex-encoded checksum: %q", out)
+	}
+	if info != nil && len(sum) != info.Length {
+		return nil, fmt.Errorf("returned %d-byte checksum, expected %d bytes", len(sum), info.Length)
+	}
+	return sum, nil
+}
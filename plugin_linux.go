@@ -0,0 +1,71 @@
+package xsum
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// rlimitHelper is the argv[0] used to re-execute the current binary to apply resource limits to a plugin.
+const rlimitHelper = "xsum-plugin-rlimit"
+
+func init() {
+	if len(os.Args) < 4 || os.Args[0] != rlimitHelper {
+		return
+	}
+	if err := execLimited(os.Args[1], os.Args[2], os.Args[3:]); err != nil {
+		fmt.Fprintf(os.Stderr, "xsum: %s: %s\n", os.Args[3], err)
+		os.Exit(126)
+	}
+}
+
+// limitCmd returns a command that executes a plugin with resource limits.
+// The current binary is re-executed to set the limits with setrlimit and then execute the plugin,
+// so that the limits apply to the plugin and any child processes from the start, without requiring a shell.
+func limitCmd(path string, args []string, l *PluginLimits) (*exec.Cmd, error) {
+	if l.CPUTime <= 0 && l.Memory <= 0 {
+		return exec.Command(path, args...), nil
+	}
+	path, err := exec.LookPath(path)
+	if err != nil {
+		return nil, err
+	}
+	var cpu, mem int64
+	if l.CPUTime > 0 {
+		cpu = int64((l.CPUTime + 999999999) / 1000000000) // seconds, rounded up
+	}
+	if l.Memory > 0 {
+		mem = l.Memory
+	}
+	return &exec.Cmd{
+		Path: "/proc/self/exe",
+		Args: append([]string{rlimitHelper, strconv.FormatInt(cpu, 10), strconv.FormatInt(mem, 10), path}, args...),
+	}, nil
+}
+
+// execLimited sets RLIMIT_CPU (in seconds) and RLIMIT_AS (in bytes), and then replaces the current process with argv.
+// Zero limits are not set.
+func execLimited(cpu, mem string, argv []string) error {
+	for _, l := range []struct {
+		resource int
+		value    string
+	}{
+		{unix.RLIMIT_CPU, cpu},
+		{unix.RLIMIT_AS, mem},
+	} {
+		n, err := strconv.ParseUint(l.value, 10, 64)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		if err := unix.Setrlimit(l.resource, &unix.Rlimit{Cur: n, Max: n}); err != nil {
+			return fmt.Errorf("set resource limit: %w", err)
+		}
+	}
+	return unix.Exec(argv[0], argv, os.Environ())
+}
@@ -0,0 +1,51 @@
+package xsum_test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/sclevine/xsum"
+)
+
+// TestMain runs the test binary as a plugin that outputs its resource limits, when XSUM_TEST_RLIMIT_PLUGIN is set.
+func TestMain(m *testing.M) {
+	if os.Getenv("XSUM_TEST_RLIMIT_PLUGIN") != "" {
+		if len(os.Args) > 1 && os.Args[1] == xsum.PluginInfoArg {
+			os.Exit(1)
+		}
+		var cpu, as unix.Rlimit
+		if err := unix.Getrlimit(unix.RLIMIT_CPU, &cpu); err != nil {
+			os.Exit(2)
+		}
+		if err := unix.Getrlimit(unix.RLIMIT_AS, &as); err != nil {
+			os.Exit(2)
+		}
+		fmt.Print(hex.EncodeToString([]byte(fmt.Sprintf("%d %d", cpu.Cur, as.Cur))))
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestHashPluginLimits_rlimit(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XSUM_TEST_RLIMIT_PLUGIN", "1")
+	t.Setenv("PATH", t.TempDir()) // limits must not depend on a shell
+
+	// limits apply before the plugin starts
+	out, err := xsum.NewHashPluginLimits("rlimit", exe, xsum.PluginLimits{CPUTime: 1500 * time.Millisecond, Memory: 4 << 30}).Data(nil)
+	if err != nil || string(out) != fmt.Sprintf("2 %d", 4<<30) {
+		t.Errorf("Expected limits, got: %s, %v", out, err)
+	}
+	out, err = xsum.NewHashPluginLimits("rlimit", exe, xsum.PluginLimits{CPUTime: time.Second}).Data(nil)
+	if err != nil || string(out) != fmt.Sprintf("1 %d", uint64(unix.RLIM_INFINITY)) {
+		t.Errorf("Expected CPU limit only, got: %s, %v", out, err)
+	}
+}
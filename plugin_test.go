@@ -0,0 +1,127 @@
+package xsum_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sclevine/xsum"
+)
+
+func testPlugin(t *testing.T, name, info, sum string) xsum.PluginHash {
+	t.Helper()
+	return xsum.NewHashPlugin(name, testScript(t, name, "if [ \"$1\" = --xsum-info ]; then\n"+
+		"  [ -z '"+info+"' ] && exit 1\n"+
+		"  echo '"+info+"'; exit 0\n"+
+		"fi\n"+
+		"cat > /dev/null\n"+
+		"printf '"+sum+"'\n"))
+}
+
+func testScript(t *testing.T, name, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin scripts require sh")
+	}
+	path := filepath.Join(t.TempDir(), "xsum-"+name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHashPluginInfo(t *testing.T) {
+	hash := testPlugin(t, "test", `{"name": "test", "version": "1.2.3", "length": 2, "types": ["data"]}`, "abcd")
+	info, err := hash.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "1.2.3" || info.Length != 2 || !info.Supports(xsum.PluginData) || info.Supports(xsum.PluginMetadata) {
+		t.Errorf("Unexpected info: %+v", info)
+	}
+	if out, err := hash.Data(strings.NewReader("data")); err != nil || !bytes.Equal(out, []byte{0xab, 0xcd}) {
+		t.Errorf("Unexpected %x, %v", out, err)
+	}
+	if _, err := hash.Metadata(nil); err == nil || !strings.Contains(err.Error(), "metadata input not supported") {
+		t.Errorf("Expected unsupported type, got: %v", err)
+	}
+
+	legacy := testPlugin(t, "legacy", "", "abcd")
+	if info, err := legacy.Info(); info != nil || err != nil {
+		t.Errorf("Expected no info, got: %v, %v", info, err)
+	}
+	if out, err := legacy.Metadata(nil); err != nil || !bytes.Equal(out, []byte{0xab, 0xcd}) {
+		t.Errorf("Unexpected %x, %v", out, err)
+	}
+
+	for _, tt := range []struct {
+		name, info, sum, err string
+	}{
+		{"length", `{"name": "length", "length": 32, "types": ["data", "metadata"]}`, "abcd", "2-byte checksum, expected 32 bytes"},
+		{"hex", `{"name": "hex", "length": 2, "types": ["data", "metadata"]}`, "hello", "not a hex-encoded checksum"},
+		{"legacy-hex", "", "hello", "not a hex-encoded checksum"},
+		{"name", `{"name": "other", "length": 2, "types": ["data"]}`, "abcd", "reports name `other'"},
+		{"zero", `{"name": "zero", "length": 0, "types": ["data"]}`, "", "invalid checksum length"},
+		{"types", `{"name": "types", "length": 2, "types": ["data", "other"]}`, "abcd", "unknown input type"},
+		{"no-types", `{"name": "no-types", "length": 2}`, "abcd", "no input types"},
+		{"json", `{"name": "json",`, "abcd", "invalid --xsum-info output"},
+	} {
+		_, err := testPlugin(t, tt.name, tt.info, tt.sum).Data(strings.NewReader("data"))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected error containing `%s', got: %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestHashPluginLimits(t *testing.T) {
+	t.Setenv("XSUM_TEST_SECRET", "secret")
+	t.Setenv("XSUM_PLUGIN_TYPE", "overridden")
+	env := testScript(t, "env", `[ "$1" = --xsum-info ] && exit 1; printf '%s' "$XSUM_TEST_SECRET$XSUM_PLUGIN_TYPE" | od -An -tx1 | tr -d ' \n'`)
+	for _, tt := range []struct {
+		env  []string
+		want string
+	}{
+		{nil, "secretdata"},
+		{xsum.DefaultPluginEnv, "data"},
+		{append(append([]string{}, xsum.DefaultPluginEnv...), "XSUM_TEST_SECRET"), "secretdata"},
+	} {
+		out, err := xsum.NewHashPluginLimits("env", env, xsum.PluginLimits{Env: tt.env}).Data(nil)
+		if err != nil || string(out) != tt.want {
+			t.Errorf("Env %v: unexpected %s, %v", tt.env, out, err)
+		}
+	}
+
+	// child process keeps stdout open
+	hang := testScript(t, "hang", `[ "$1" = --xsum-info ] && exit 1; sleep 10 & wait`)
+	start := time.Now()
+	_, err := xsum.NewHashPluginLimits("hang", hang, xsum.PluginLimits{Timeout: 200 * time.Millisecond}).Data(nil)
+	var pErr *xsum.PluginError
+	if !errors.As(err, &pErr) || pErr.Name != "hang" || pErr.Path != hang || !errors.Is(err, xsum.ErrPluginTimeout) {
+		t.Errorf("Expected timeout, got: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Expected timeout to kill child process, took %s", d)
+	}
+	if err != nil && err.Error() != "plugin xsum-hang: timed out after 200ms" {
+		t.Errorf("Unexpected message: %s", err)
+	}
+
+	noisy := testScript(t, "noisy", `[ "$1" = --xsum-info ] && exit 1; yes error | head -c 100000 >&2; exit 1`)
+	_, err = xsum.NewHashPluginLimits("noisy", noisy, xsum.PluginLimits{Stderr: 100}).Data(nil)
+	if err == nil || !strings.Contains(err.Error(), "[stderr truncated]") || len(err.Error()) > 300 {
+		t.Errorf("Expected truncated stderr, got: %v", err)
+	}
+
+	if runtime.GOOS == "linux" {
+		spin := testScript(t, "spin", `[ "$1" = --xsum-info ] && exit 1; while :; do :; done`)
+		_, err = xsum.NewHashPluginLimits("spin", spin, xsum.PluginLimits{CPUTime: time.Second, Timeout: time.Minute}).Data(nil)
+		if err == nil || errors.Is(err, xsum.ErrPluginTimeout) {
+			t.Errorf("Expected CPU time limit, got: %v", err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package xsum
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup only kills the plugin process, because child processes are not tracked on Windows.
+func killProcessGroup(p *os.Process) {
+	p.Kill()
+}
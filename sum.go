@@ -114,20 +114,19 @@ func (s *Sum) Each(files <-chan File, fn func(*Node) error) error {
 	return nil
 }
 
-func (s *Sum) acquireCPU() {
-	sem := DefaultSemaphore
+func (s *Sum) semaphore() *semaphore.Weighted {
 	if s.Semaphore != nil {
-		sem = s.Semaphore
+		return s.Semaphore
 	}
-	sem.Acquire(context.Background(), 1)
+	return DefaultSemaphore
+}
+
+func (s *Sum) acquireCPU() {
+	s.semaphore().Acquire(context.Background(), 1)
 }
 
 func (s *Sum) releaseCPU() {
-	sem := DefaultSemaphore
-	if s.Semaphore != nil {
-		sem = s.Semaphore
-	}
-	sem.Release(1)
+	s.semaphore().Release(1)
 }
 
 // If passed, sched is called exactly once when all remaining work has acquired locks on the CPU
@@ -169,7 +168,10 @@ func (s *Sum) walkFile(file File, subdir bool, sched func()) *Node {
 		}
 	}
 
-	var sum []byte
+	var (
+		sum      []byte
+		warnings []PluginWarning
+	)
 	switch {
 	case fi.IsDir():
 		if s.NoDirs {
@@ -197,6 +199,7 @@ func (s *Sum) walkFile(file File, subdir bool, sched func()) *Node {
 				}
 				return newFileErrorNode("", file, subdir, n.Err)
 			}
+			warnings = append(warnings, n.Warnings...)
 			var name string
 			if file.Mask.Attr&AttrNoName == 0 {
 				// safe because subdir nodes have generated bases
@@ -244,7 +247,7 @@ func (s *Sum) walkFile(file File, subdir bool, sched func()) *Node {
 			file.Mask.Attr |= AttrNoData
 		} else {
 			file.Mask.Attr &= ^AttrNoData
-			sum, err = file.sum()
+			sum, warnings, err = file.sum(s.semaphore())
 			if err != nil {
 				return newFileErrorNode("hash", file, subdir, err)
 			}
@@ -252,11 +255,12 @@ func (s *Sum) walkFile(file File, subdir bool, sched func()) *Node {
 	}
 
 	n := &Node{
-		File:  file,
-		Sum:   sum,
-		Mode:  fi.Mode(),
-		Sys:   sys,
-		Xattr: xattr,
+		File:     file,
+		Sum:      sum,
+		Mode:     fi.Mode(),
+		Sys:      sys,
+		Xattr:    xattr,
+		Warnings: warnings,
 	}
 	if inclusive && !subdir {
 		n.Sum, err = hashFileAttr(n)
@@ -0,0 +1,310 @@
+package xsum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/semaphore"
+
+	"github.com/sclevine/xsum/encoding"
+)
+
+// concurrentHash is implemented by Hashes that may use more than one CPU to hash a single file.
+type concurrentHash interface {
+	// sem must already be acquired once by the caller
+	concurrentFile(path string, sem *semaphore.Weighted) ([]byte, error)
+}
+
+// NewHashTree returns a Hash that calculates a binary tree hash using func fn.
+// Data is split into leaves of leafSize bytes, and adjacent pairs of hashes are combined until one hash remains.
+// When leafSize is 1 MiB and fn is sha256.New, the result is equivalent to the AWS Glacier tree hash.
+// Leaves of large files are hashed concurrently when capacity is available in the Sum Semaphore.
+func NewHashTree(name string, fn func() hash.Hash, leafSize int64) Hash {
+	return &hashTree{
+		name: name,
+		fn:   fn,
+		size: leafSize,
+	}
+}
+
+type hashTree struct {
+	name string
+	fn   func() hash.Hash
+	size int64
+}
+
+func (h *hashTree) String() string {
+	return h.name
+}
+
+func (h *hashTree) Metadata(b []byte) ([]byte, error) {
+	return h.Data(bytes.NewReader(b))
+}
+
+func (h *hashTree) Data(r io.Reader) ([]byte, error) {
+	var leaves [][]byte
+	for {
+		hf := h.fn()
+		n, err := io.CopyN(hf, r, h.size)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if n > 0 || len(leaves) == 0 {
+			leaves = append(leaves, hf.Sum(nil))
+		}
+		if n < h.size {
+			break
+		}
+	}
+	return h.root(leaves), nil
+}
+
+func (h *hashTree) File(path string) ([]byte, error) {
+	return h.concurrentFile(path, nil)
+}
+
+func (h *hashTree) concurrentFile(path string, sem *semaphore.Weighted) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return h.Data(f)
+	}
+	n := int((fi.Size() + h.size - 1) / h.size)
+	if n == 0 {
+		n = 1
+	}
+	leaves := make([][]byte, n)
+	if err := eachConcurrent(n, sem, func(i int) error {
+		hf := h.fn()
+		if _, err := io.Copy(hf, io.NewSectionReader(f, int64(i)*h.size, h.size)); err != nil {
+			return err
+		}
+		leaves[i] = hf.Sum(nil)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return h.root(leaves), nil
+}
+
+func (h *hashTree) root(hashes [][]byte) []byte {
+	for len(hashes) > 1 {
+		next := make([][]byte, 0, (len(hashes)+1)/2)
+		for i := 0; i+1 < len(hashes); i += 2 {
+			hf := h.fn()
+			hf.Write(hashes[i])
+			hf.Write(hashes[i+1])
+			next = append(next, hf.Sum(nil))
+		}
+		if len(hashes)%2 != 0 {
+			next = append(next, hashes[len(hashes)-1])
+		}
+		hashes = next
+	}
+	return hashes[0]
+}
+
+// eachConcurrent calls fn for each i in [0, n).
+// The calling goroutine always participates, so sem is only acquired opportunistically to avoid deadlock.
+// If sem is nil, all calls happen sequentially.
+func eachConcurrent(n int, sem *semaphore.Weighted, fn func(i int) error) error {
+	var (
+		next    int64 = -1
+		failed  int32
+		errOnce sync.Once
+		err     error
+		wg      sync.WaitGroup
+	)
+	work := func() bool {
+		i := int(atomic.AddInt64(&next, 1))
+		if i >= n || atomic.LoadInt32(&failed) != 0 {
+			return false
+		}
+		if fErr := fn(i); fErr != nil {
+			errOnce.Do(func() { err = fErr })
+			atomic.StoreInt32(&failed, 1)
+			return false
+		}
+		return true
+	}
+	for workers := 1; ; {
+		// check for free CPUs between each unit of work
+		if sem != nil && workers < n && sem.TryAcquire(1) {
+			workers++
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer sem.Release(1)
+				for work() {
+				}
+			}()
+		}
+		if !work() {
+			break
+		}
+	}
+	wg.Wait()
+	return err
+}
+
+// NewHashChunked returns a Hash that splits file data into chunks of chunkSize bytes.
+// Each chunk is hashed independently using Hash h, and the resulting hashes are combined using a DER-encoded HashTree.
+// Each entry in the HashTree is named by the big-endian offset of its chunk.
+// Chunks of large files are hashed concurrently when capacity is available in the Sum Semaphore.
+// Metadata is hashed directly using Hash h.
+// The chunk size is encoded in the name of the returned Hash (e.g., sha256@64m).
+func NewHashChunked(h Hash, chunkSize int64) Hash {
+	return &hashChunked{
+		hash: h,
+		size: chunkSize,
+	}
+}
+
+type hashChunked struct {
+	hash Hash
+	size int64
+}
+
+func (h *hashChunked) String() string {
+	return h.hash.String() + "@" + formatSize(h.size)
+}
+
+func (h *hashChunked) Metadata(b []byte) ([]byte, error) {
+	return h.hash.Metadata(b)
+}
+
+func (h *hashChunked) Data(r io.Reader) ([]byte, error) {
+	var chunks []encoding.NamedHash
+	for off := int64(0); ; off += h.size {
+		cr := &countReader{r: io.LimitReader(r, h.size)}
+		sum, err := h.hash.Data(cr)
+		if err != nil {
+			return nil, err
+		}
+		if cr.n > 0 || off == 0 {
+			chunks = append(chunks, h.chunk(off, sum))
+		}
+		if cr.n < h.size {
+			break
+		}
+	}
+	return h.root(chunks)
+}
+
+func (h *hashChunked) File(path string) ([]byte, error) {
+	return h.concurrentFile(path, nil)
+}
+
+func (h *hashChunked) concurrentFile(path string, sem *semaphore.Weighted) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return h.Data(f)
+	}
+	n := int((fi.Size() + h.size - 1) / h.size)
+	if n == 0 {
+		n = 1
+	}
+	chunks := make([]encoding.NamedHash, n)
+	if err := eachConcurrent(n, sem, func(i int) error {
+		off := int64(i) * h.size
+		sum, err := h.hash.Data(io.NewSectionReader(f, off, h.size))
+		if err != nil {
+			return err
+		}
+		chunks[i] = h.chunk(off, sum)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return h.root(chunks)
+}
+
+func (h *hashChunked) chunk(off int64, sum []byte) encoding.NamedHash {
+	name := make([]byte, 8)
+	binary.BigEndian.PutUint64(name, uint64(off))
+	return encoding.NamedHash{
+		Hash: sum,
+		Name: name,
+	}
+}
+
+func (h *hashChunked) root(chunks []encoding.NamedHash) ([]byte, error) {
+	der, err := encoding.TreeASN1DER(hashToEncoding(h.hash.String()), chunks)
+	if err != nil {
+		return nil, err
+	}
+	return h.hash.Metadata(der)
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var sizeUnits = []struct {
+	unit string
+	size int64
+}{
+	{"t", 1 << 40},
+	{"g", 1 << 30},
+	{"m", 1 << 20},
+	{"k", 1 << 10},
+}
+
+func formatSize(n int64) string {
+	for _, u := range sizeUnits {
+		if n >= u.size && n%u.size == 0 {
+			return strconv.FormatInt(n/u.size, 10) + u.unit
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// ParseSize parses a size in bytes with an optional, case-insensitive binary unit suffix (k, m, g, or t).
+// For example, 64m represents 64 MiB.
+func ParseSize(s string) (int64, error) {
+	num := strings.ToLower(s)
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(num, u.unit) {
+			num = strings.TrimSuffix(num, u.unit)
+			mult = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("invalid size `%s'", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,169 @@
+package xsum_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sync/semaphore"
+
+	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/cli"
+	"github.com/sclevine/xsum/encoding"
+)
+
+func TestNewHashTree(t *testing.T) {
+	const leaf = 1024
+	h := xsum.NewHashTree("test", sha256.New, leaf)
+	dir := t.TempDir()
+	sum := &xsum.Sum{Semaphore: semaphore.NewWeighted(4)}
+
+	for _, size := range []int{0, 1, leaf, leaf + 1, 3 * leaf, 7*leaf + 100} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+		exp := glacierTreeHash(sha256.New, data, leaf)
+
+		out, err := h.Data(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, exp) {
+			t.Errorf("hashTree.Data([%d bytes]) = %x, expected %x", size, out, exp)
+		}
+
+		path := filepath.Join(dir, "file")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		nodes, err := sum.Find([]xsum.File{{Hash: h, Path: path}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(nodes[0].Sum, exp) {
+			t.Errorf("xsum.Find([%d byte tree hash file]) = %x, expected %x", size, nodes[0].Sum, exp)
+		}
+	}
+}
+
+// TestGlacierTreeHash checks sha256-tree against published Amazon S3 Glacier tree hash vectors (from boto's Glacier tests).
+func TestGlacierTreeHash(t *testing.T) {
+	h, err := cli.ParseHash("sha256-tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const mib = 1 << 20
+	for _, tt := range []struct {
+		size int
+		sum  string
+	}{
+		{mib, "9bc1b2a288b26af7257a36277ae3816a7d4f16e89c1e7e77d0a5c48bad62b360"},
+		{2 * mib, "560c2c9333c719cb00cfdffee3ba293db17f58743cdd1f7e4055373ae6300afa"},
+		{4 * mib, "9491cb2ed1d4e7cd53215f4017c23ec4ad21d7050a1e6bb636c4f67e8cddb844"},
+		{4*mib + 20, "12f3cbd6101b981cde074039f6f728071da8879d6f632de8afc7cdf00661b08f"},
+	} {
+		data := bytes.Repeat([]byte("a"), tt.size)
+		out, err := h.Data(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(out) != tt.sum {
+			t.Errorf("sha256-tree([%d bytes]) = %x, expected %s", tt.size, out, tt.sum)
+		}
+		path := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := h.File(path); err != nil || hex.EncodeToString(out) != tt.sum {
+			t.Errorf("sha256-tree(file [%d bytes]) = %x, %v, expected %s", tt.size, out, err, tt.sum)
+		}
+	}
+}
+
+func glacierTreeHash(fn func() hash.Hash, data []byte, leaf int) []byte {
+	var hashes [][]byte
+	for i := 0; i == 0 || i < len(data); i += leaf {
+		end := i + leaf
+		if end > len(data) {
+			end = len(data)
+		}
+		hf := fn()
+		hf.Write(data[i:end])
+		hashes = append(hashes, hf.Sum(nil))
+	}
+	for len(hashes) > 1 {
+		var next [][]byte
+		for i := 0; i < len(hashes); i += 2 {
+			if i+1 == len(hashes) {
+				next = append(next, hashes[i])
+				continue
+			}
+			hf := fn()
+			hf.Write(hashes[i])
+			hf.Write(hashes[i+1])
+			next = append(next, hf.Sum(nil))
+		}
+		hashes = next
+	}
+	return hashes[0]
+}
+
+func TestNewHashChunked(t *testing.T) {
+	const chunk = 1024
+	inner := xsum.NewHashFunc(xsum.HashSHA256, sha256.New)
+	h := xsum.NewHashChunked(inner, chunk)
+	if name := h.String(); name != "sha256@1k" {
+		t.Errorf("hashChunked.String() = %s, expected sha256@1k", name)
+	}
+	dir := t.TempDir()
+	sum := &xsum.Sum{Semaphore: semaphore.NewWeighted(4)}
+
+	for _, size := range []int{0, 1, chunk, 5*chunk + 1} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+		var chunks []encoding.NamedHash
+		for off := 0; off == 0 || off < size; off += chunk {
+			end := off + chunk
+			if end > size {
+				end = size
+			}
+			c := sha256.Sum256(data[off:end])
+			name := make([]byte, 8)
+			binary.BigEndian.PutUint64(name, uint64(off))
+			chunks = append(chunks, encoding.NamedHash{Hash: c[:], Name: name})
+		}
+		der, err := encoding.TreeASN1DER(encoding.HashSHA256, chunks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := sha256.Sum256(der)
+
+		out, err := h.Data(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, exp[:]) {
+			t.Errorf("hashChunked.Data([%d bytes]) = %x, expected %x", size, out, exp)
+		}
+
+		path := filepath.Join(dir, "file")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		nodes, err := sum.Find([]xsum.File{{Hash: h, Path: path}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(nodes[0].Sum, exp[:]) {
+			t.Errorf("xsum.Find([%d byte chunked file]) = %x, expected %x", size, nodes[0].Sum, exp)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package wasm runs xsum plugins compiled to WebAssembly in an embedded WASI runtime.
+// See PLUGIN.md for details.
+package wasm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+
+	"github.com/sclevine/xsum"
+)
+
+// NewHashPlugin returns an xsum.PluginHash backed by the xsum WebAssembly plugin at the specified path.
+// The plugin is run in an embedded WASI runtime with no filesystem access, and receives all data via stdin.
+func NewHashPlugin(name, path string) xsum.PluginHash {
+	return NewHashPluginLimits(name, path, xsum.PluginLimits{})
+}
+
+// NewHashPluginLimits is similar to NewHashPlugin, but the plugin is executed within the provided limits.
+// PluginLimits.Env and PluginLimits.CPUTime do not apply to WebAssembly plugins.
+func NewHashPluginLimits(name, path string, limits xsum.PluginLimits) xsum.PluginHash {
+	return xsum.NewHashPluginRuntime(name, path, limits, &pluginRuntime{
+		name:   name,
+		memory: limits.Memory,
+	})
+}
+
+// pluginRuntime is an xsum.PluginRuntime that runs a single WebAssembly plugin.
+type pluginRuntime struct {
+	name   string
+	memory int64
+
+	once     sync.Once
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	err      error
+	runs     uint64
+}
+
+// compile compiles the plugin once, so that it may be instantiated concurrently for each input.
+func (r *pluginRuntime) compile(ctx context.Context, path string) error {
+	r.once.Do(func() {
+		bin, err := os.ReadFile(path)
+		if err != nil {
+			r.err = err
+			return
+		}
+		// running modules are closed once their context is done, so that they cannot outlive a timeout
+		config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+		if r.memory > 0 {
+			limit := uint32(65536)
+			if pages := r.memory / 65536; pages < int64(limit) {
+				limit = uint32(pages)
+			}
+			config = config.WithMemoryLimitPages(limit)
+		}
+		r.runtime = wazero.NewRuntimeWithConfig(ctx, config)
+		if _, err := wasi_snapshot_preview1.Instantiate(ctx, r.runtime); err != nil {
+			r.err = err
+			return
+		}
+		r.compiled, r.err = r.runtime.CompileModule(ctx, bin)
+		if r.err == nil && r.compiled.ExportedFunctions()["_start"] == nil {
+			r.err = errors.New("module does not export _start")
+		}
+	})
+	return r.err
+}
+
+// Exec instantiates the compiled plugin and runs it to completion, or until ctx is done.
+// A plugin blocked on input or output when ctx is done is closed once the blocking call returns.
+func (r *pluginRuntime) Exec(ctx context.Context, path string, args, env []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if err := r.compile(context.Background(), path); err != nil {
+		return err
+	}
+	if stdin == nil {
+		stdin = strings.NewReader("")
+	}
+	config := wazero.NewModuleConfig().
+		WithName(r.name + "-" + strconv.FormatUint(atomic.AddUint64(&r.runs, 1), 10)).
+		WithArgs(append([]string{"xsum-" + r.name}, args...)...).
+		WithStdin(&ctxReader{ctx, stdin}).
+		WithStdout(&ctxWriter{ctx, stdout}).
+		WithStderr(&ctxWriter{ctx, stderr}).
+		WithStartFunctions() // _start is called below
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			config = config.WithEnv(kv[:i], kv[i+1:])
+		}
+	}
+	mod, err := r.runtime.InstantiateModule(ctx, r.compiled, config)
+	if err != nil {
+		return err
+	}
+	defer mod.Close(ctx)
+	_, err = mod.ExportedFunction("_start").Call(ctx)
+	if eErr, ok := err.(*sys.ExitError); ok && eErr.ExitCode() == 0 {
+		err = nil
+	}
+	return err
+}
+
+// ctxReader fails once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// ctxWriter fails once ctx is done.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c *ctxWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
@@ -0,0 +1,149 @@
+package wasm_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sclevine/xsum"
+	"github.com/sclevine/xsum/wasm"
+)
+
+// testWASM assembles a WASI module that imports fd_read and fd_write, and runs body as _start.
+func testWASM(body []byte) []byte {
+	section := func(id byte, content ...byte) []byte {
+		return append([]byte{id, byte(len(content))}, content...)
+	}
+	wasi := func(name string) []byte {
+		b := append([]byte{22}, "wasi_snapshot_preview1"...)
+		b = append(append(b, byte(len(name))), name...)
+		return append(b, 0x00, 0x00)
+	}
+	imports := append(append([]byte{2}, wasi("fd_read")...), wasi("fd_write")...)
+	code := append([]byte{1, byte(len(body) + 1), 0x00}, body...)
+
+	var bin []byte
+	for _, s := range [][]byte{
+		[]byte("\x00asm\x01\x00\x00\x00"),
+		section(1, 2, 0x60, 4, 0x7f, 0x7f, 0x7f, 0x7f, 1, 0x7f, 0x60, 0, 0),
+		section(2, imports...),
+		section(3, 1, 1),
+		section(5, 1, 0x00, 1),
+		section(7, append(append([]byte{2, 6}, "memory\x02\x00\x06"...), "_start\x00\x02"...)...),
+		section(10, code...),
+	} {
+		bin = append(bin, s...)
+	}
+	return bin
+}
+
+// wasmCat copies stdin to stdout.
+var wasmCat = []byte{
+	0x03, 0x40, // loop
+	0x41, 0x00, 0x41, 0xc0, 0x00, 0x36, 0x02, 0x00, // iov.buf = 64
+	0x41, 0x04, 0x41, 0x80, 0x08, 0x36, 0x02, 0x00, // iov.len = 1024
+	0x41, 0x00, 0x41, 0x00, 0x41, 0x01, 0x41, 0x10, 0x10, 0x00, 0x1a, // fd_read(0, iov, 1, &n)
+	0x41, 0x10, 0x28, 0x02, 0x00, 0x45, 0x04, 0x40, 0x0f, 0x0b, // if n == 0 return
+	0x41, 0x04, 0x41, 0x10, 0x28, 0x02, 0x00, 0x36, 0x02, 0x00, // iov.len = n
+	0x41, 0x01, 0x41, 0x00, 0x41, 0x01, 0x41, 0x10, 0x10, 0x01, 0x1a, // fd_write(1, iov, 1, &n)
+	0x0c, 0x00, // br loop
+	0x0b, // end
+	0x0b,
+}
+
+func TestHashPluginWASM(t *testing.T) {
+	tmp := t.TempDir()
+	write := func(name string, b []byte) string {
+		t.Helper()
+		path := filepath.Join(tmp, name)
+		if err := os.WriteFile(path, b, 0666); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	hash := wasm.NewHashPlugin("cat", write("xsum-cat.wasm", testWASM(wasmCat)))
+	if s := hash.String(); s != "cat" {
+		t.Errorf("Unexpected name %s", s)
+	}
+
+	input := bytes.Repeat([]byte("0123456789abcdef"), 200)
+	want := bytes.Repeat([]byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}, 200)
+	if out, err := hash.Metadata(input); err != nil || !bytes.Equal(out, want) {
+		t.Errorf("Metadata: unexpected %x, %v", out, err)
+	}
+	if out, err := hash.Data(bytes.NewReader(input)); err != nil || !bytes.Equal(out, want) {
+		t.Errorf("Data: unexpected %x, %v", out, err)
+	}
+	if out, err := hash.File(write("input", input)); err != nil || !bytes.Equal(out, want) {
+		t.Errorf("File: unexpected %x, %v", out, err)
+	}
+	if _, err := hash.Metadata([]byte("not hex")); err == nil {
+		t.Error("Expected invalid output to fail")
+	}
+
+	trap := wasm.NewHashPlugin("trap", write("xsum-trap.wasm", testWASM([]byte{0x00, 0x0b})))
+	if _, err := trap.Metadata(nil); err == nil {
+		t.Error("Expected trap to fail")
+	}
+	for name, bin := range map[string][]byte{
+		"invalid":  []byte("invalid"),
+		"no-start": []byte("\x00asm\x01\x00\x00\x00"),
+	} {
+		invalid := wasm.NewHashPlugin(name, write("xsum-"+name+".wasm", bin))
+		if _, err := invalid.Metadata(nil); err == nil {
+			t.Errorf("%s: expected invalid module to fail", name)
+		}
+	}
+}
+
+// blockingReader blocks until released, and then returns EOF.
+type blockingReader chan struct{}
+
+func (r blockingReader) Read([]byte) (int, error) {
+	<-r
+	return 0, io.EOF
+}
+
+func TestHashPluginWASMLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xsum-cat.wasm")
+	if err := os.WriteFile(path, testWASM(wasmCat), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(blockingReader)
+	defer close(release)
+	hash := wasm.NewHashPluginLimits("cat", path, xsum.PluginLimits{Timeout: 100 * time.Millisecond})
+	_, err := hash.Data(release)
+	var pErr *xsum.PluginError
+	if !errors.As(err, &pErr) || pErr.Name != "cat" || !errors.Is(err, xsum.ErrPluginTimeout) {
+		t.Errorf("Expected timeout, got: %v", err)
+	}
+
+	// spinning modules are closed as soon as they time out, instead of after the grace period for blocked I/O
+	spin := filepath.Join(filepath.Dir(path), "xsum-spin.wasm")
+	if err := os.WriteFile(spin, testWASM([]byte{0x03, 0x40, 0x0c, 0x00, 0x0b, 0x0b}), 0666); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = wasm.NewHashPluginLimits("spin", spin, xsum.PluginLimits{Timeout: 100 * time.Millisecond}).Metadata(nil)
+	if !errors.Is(err, xsum.ErrPluginTimeout) {
+		t.Errorf("Expected timeout, got: %v", err)
+	}
+	if d := time.Since(start); d >= time.Second {
+		t.Errorf("Expected spinning module to be closed, took %s", d)
+	}
+
+	hash = wasm.NewHashPluginLimits("cat", path, xsum.PluginLimits{Memory: 1000})
+	if _, err := hash.Metadata(nil); err == nil || !strings.Contains(err.Error(), "over limit") {
+		t.Errorf("Expected memory limit, got: %v", err)
+	}
+	hash = wasm.NewHashPluginLimits("cat", path, xsum.PluginLimits{Memory: 1 << 20})
+	if out, err := hash.Metadata([]byte("abcd")); err != nil || !bytes.Equal(out, []byte{0xab, 0xcd}) {
+		t.Errorf("Unexpected %x, %v", out, err)
+	}
+}